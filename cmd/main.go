@@ -1,297 +1,1686 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/grainedlotus515/godotctl/internal/config"
 	"github.com/grainedlotus515/godotctl/internal/installer"
 	"github.com/grainedlotus515/godotctl/internal/manifest"
 	"github.com/grainedlotus515/godotctl/internal/ui"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
-	version = "0.1.0"
-	auto    bool
+	version     = "0.1.0"
+	auto        bool
+	strategy    string
+	homeDir     string
+	rootDir     string
+	commit      bool
+	dryRun      bool
+	asJSON      bool
+	wait        bool
+	groups      []string
+	installName string
+
+	changesState bool // The command takes the state lock, unlike dry runs and read-only commands
+
+	stateLock *installer.StateLock // Held by commands that change installed state
+	resuming  *installer.Journal   // Interrupted operation the user chose to resume
 )
 
-func main() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+func main() {
+	err := rootCmd.Execute()
+	stateLock.Release()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// loadConfig builds the configuration honoring the global --home and --root flags
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.New(config.Options{Home: homeDir, Root: rootDir})
+	if err != nil {
+		return nil, err
+	}
+
+	// Moving data left behind by versions that ignored the XDG variables and
+	// recovering interrupted operations is left to commands that take the
+	// state lock; anything else only points them out
+	if !changesState {
+		if dirs := cfg.UnmigratedDirs(); len(dirs) > 0 {
+			ui.PrintWarning(fmt.Sprintf("Data of an older godotctl is still in %s; the next command that changes state moves it", strings.Join(dirs, ", ")))
+		}
+
+		journal, err := installer.New(cfg).Interrupted()
+		if err != nil {
+			return nil, err
+		}
+		if journal != nil {
+			ui.PrintWarning(fmt.Sprintf("The %s of %s was interrupted; the next command that changes state resumes or rolls it back", journal.Operation, journal.Repo))
+		}
+	}
+
+	return cfg, nil
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "godotctl",
+	Short: "A dotfiles installer for CachyOS",
+	Long:  `Manage your dotfiles with symlinks, backups, and hooks.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Commands that change state are the ones that can wait for the lock
+		changesState = cmd.Flags().Lookup("wait") != nil && !dryRun
+	},
+}
+
+var installCmd = &cobra.Command{
+	Use:   "install [repository-url-or-path]",
+	Short: "Install dotfiles from a git repository or local directory",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkPlanFlags(); err != nil {
+			return err
+		}
+
+		// Initialize configuration
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to initialize config: %w", err)
+		}
+
+		return installSource(cfg, args[0], installName, nil)
+	},
+}
+
+// installSource installs groups of a repository under name, or a name
+// derived from source when empty. choose picks the groups from those
+// scanned; without it the user is asked, or every group is taken with --auto.
+func installSource(cfg *config.Config, source, name string, choose func([]installer.DotfileGroup) ([]installer.DotfileGroup, error)) (err error) {
+	ui.PrintHeader("Installing Dotfiles")
+	ui.PrintInfo(fmt.Sprintf("Source: %s", source))
+
+	if !dryRun {
+		if err := lockState(cfg); err != nil {
+			return err
+		}
+	}
+
+	// Initialize installer
+	inst := installer.New(cfg)
+	if strategy != "" {
+		s, err := installer.ParseStrategy(strategy)
+		if err != nil {
+			return err
+		}
+		inst.SetStrategy(s)
+	}
+
+	name, err = resolveName(cfg, inst, source, name)
+	if err != nil {
+		return err
+	}
+
+	// Everything from here on is undone if the install fails, a fresh clone
+	// included. Hooks only need to run when something changed, possibly by
+	// the interrupted run being resumed.
+	resumed := resuming != nil && resuming.Operation == "install" && resuming.Repo == name
+	if !dryRun {
+		if err := begin(inst, "install", name); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				err = rollback(inst, err)
+			} else if commitErr := inst.Commit(); commitErr != nil {
+				ui.PrintWarning(commitErr.Error())
+			}
+		}()
+	}
+
+	// Clone/copy repository, or only look at it for a dry run
+	ui.PrintInfo("Preparing repository...")
+	var (
+		repoPath, repoName string
+		sourceType         installer.SourceType
+		preview            installer.Preview
+	)
+	if dryRun {
+		preview, err = inst.PreviewClone(source, name)
+		if err != nil {
+			return fmt.Errorf("failed to prepare repository: %w", err)
+		}
+		defer preview.Cleanup()
+		repoPath, repoName, sourceType = preview.Contents, preview.RepoName, preview.SourceType
+	} else {
+		repoPath, repoName, sourceType, err = inst.Clone(source, name)
+		if err != nil {
+			return fmt.Errorf("failed to prepare repository: %w", err)
+		}
+	}
+	ui.PrintSuccess(fmt.Sprintf("Prepared at %s (type: %s)", repoPath, sourceType))
+
+	// Scan dotfiles structure
+	ui.PrintInfo("Scanning dotfiles...")
+	groups, err := inst.Scan(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to scan dotfiles: %w", err)
+	}
+	ui.PrintSuccess(fmt.Sprintf("Found %d configuration groups", len(groups)))
+
+	var available []string
+	for _, group := range groups {
+		available = append(available, group.Name)
+	}
+
+	// Show selection prompt (unless auto mode)
+	var selectedGroups []installer.DotfileGroup
+	switch {
+	case choose != nil:
+		if selectedGroups, err = choose(groups); err != nil {
+			return err
+		}
+	case auto:
+		selectedGroups = groups
+	default:
+		selectedGroups, err = ui.PromptSelectGroups(groups)
+		if err != nil {
+			return fmt.Errorf("selection cancelled: %w", err)
+		}
+	}
+
+	if len(selectedGroups) == 0 {
+		ui.PrintInfo("No groups selected, exiting")
+		return nil
+	}
+
+	// Check for conflicts and backup
+	ui.PrintInfo("Checking for existing files...")
+	conflicts, err := inst.CheckConflicts(selectedGroups)
+	if err != nil {
+		return fmt.Errorf("failed to check conflicts: %w", err)
+	}
+
+	// Leave groups whose targets another repository owns untouched
+	skipped := make(map[string]bool)
+	for _, conflict := range conflicts {
+		if conflict.Kind == installer.ConflictOwnership {
+			ui.PrintWarning(fmt.Sprintf("%s is managed by %s, skipping %s", conflict.Path, conflict.Owner, conflict.Group))
+			skipped[conflict.Group] = true
+		}
+	}
+
+	var foreign []installer.Conflict
+	for _, conflict := range conflicts {
+		if conflict.Kind == installer.ConflictForeign && !skipped[conflict.Group] {
+			foreign = append(foreign, conflict)
+		}
+	}
+
+	// Decide what to do with each existing file
+	resolutions := make(map[string]installer.Resolution)
+	if len(foreign) > 0 {
+		ui.PrintWarning(fmt.Sprintf("Found %d existing files", len(foreign)))
+
+		var applyAll installer.Resolution
+		for idx, conflict := range foreign {
+			var resolution installer.Resolution
+
+			switch {
+			case skipped[conflict.Group]:
+				resolution = installer.ResolveSkip
+			case auto:
+				resolution = installer.ResolveBackup
+			case applyAll != "":
+				resolution = applyAll
+			default:
+				var all bool
+				resolution, all, err = ui.PromptConflict(conflict, len(foreign)-idx-1, func() error {
+					return inst.Diff(conflict)
+				})
+				if err != nil {
+					return fmt.Errorf("installation cancelled")
+				}
+				if all {
+					applyAll = resolution
+				}
+			}
+
+			resolutions[conflict.Path] = resolution
+			if resolution == installer.ResolveSkip {
+				skipped[conflict.Group] = true
+			}
+		}
+
+		// Skipping any path of a group leaves the whole group alone
+		for _, conflict := range foreign {
+			if skipped[conflict.Group] {
+				resolutions[conflict.Path] = installer.ResolveSkip
+			}
+		}
+	}
+
+	var skippedGroups []string
+	selectedGroups = slices.DeleteFunc(selectedGroups, func(g installer.DotfileGroup) bool {
+		if skipped[g.Name] {
+			skippedGroups = append(skippedGroups, g.Name)
+			return true
+		}
+		return false
+	})
+
+	// Work out every change before making any
+	plan, err := inst.PlanInstall(repoName, selectedGroups, foreign, resolutions)
+	if err != nil {
+		return err
+	}
+	plan.Skipped = skippedGroups
+
+	// Discover hooks
+	changed := plan.Links.Changed > 0 || resumed
+	hooks, hooksErr := inst.DiscoverHooks(repoPath)
+	if changed {
+		for _, hook := range hooks {
+			plan.Hooks = append(plan.Hooks, hook.Name)
+		}
+	}
+
+	revision := installer.ReadRevision(repoPath)
+
+	if dryRun {
+		plan.Fetch = preview.Fetch
+		plan.Manifest = []string{fmt.Sprintf("record %s with groups %v", repoName, plan.Groups)}
+		if revision != nil {
+			plan.Manifest = append(plan.Manifest, fmt.Sprintf("record revision %s", formatRevision(revision)))
+		}
+		return ui.PrintPlan(plan, asJSON)
+	}
+
+	ui.PrintInfo("Creating symlinks...")
+	links, err := inst.Execute(plan)
+	if err != nil {
+		return err
+	}
+	if plan.BackupDir != "" {
+		ui.PrintSuccess(fmt.Sprintf("Backed up to %s", plan.BackupDir))
+	}
+	if links.Changed == 0 {
+		ui.PrintSuccess("Everything is already in place")
+	} else {
+		ui.PrintSuccess(fmt.Sprintf("Created or updated %d links and files", links.Changed))
+	}
+
+	if hooksErr != nil {
+		ui.PrintWarning(fmt.Sprintf("Failed to discover hooks: %v", hooksErr))
+	} else if len(hooks) > 0 && !changed {
+		ui.PrintInfo("Nothing changed, skipping post-install hooks")
+	} else if len(hooks) > 0 {
+		ui.PrintInfo(fmt.Sprintf("Found %d post-install hooks", len(hooks)))
+
+		var hookErr error
+		if !auto {
+			runHooks, err := ui.PromptConfirm("Run post-install hooks?")
+			if err == nil && runHooks {
+				hookErr = inst.RunHooks(hooks, false)
+			}
+		} else {
+			hookErr = inst.RunHooks(hooks, true)
+		}
+
+		if hookErr != nil && cfg.Settings.Hooks.RollbackOnFailure {
+			return fmt.Errorf("post-install hooks failed: %w", hookErr)
+		}
+		if hookErr != nil {
+			ui.PrintWarning(fmt.Sprintf("Some hooks failed: %v", hookErr))
+		}
+	}
+
+	// Save manifest
+	ui.PrintInfo("Saving installation manifest...")
+	man := manifest.New(cfg)
+	err = man.AddRepo(manifest.Install{
+		Name:        repoName,
+		URL:         installer.CanonicalSource(source),
+		CachedAt:    repoPath,
+		SourceType:  sourceType,
+		Revision:    revision,
+		Groups:      selectedGroups,
+		Skipped:     skippedGroups,
+		Available:   available,
+		Links:       links,
+		Facts:       inst.Facts(),
+		Resolutions: resolutions,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+	ui.PrintSuccess("Manifest saved")
+
+	if err := inst.Commit(); err != nil {
+		ui.PrintWarning(err.Error())
+	}
+
+	// Apply the backup retention policy now that new backups may exist
+	pruned, err := inst.PruneBackups(false)
+	if err != nil {
+		ui.PrintWarning(fmt.Sprintf("Failed to prune backups: %v", err))
+	} else if len(pruned.Removed) > 0 {
+		ui.PrintInfo(fmt.Sprintf("Pruned %d old backups, reclaimed %s", len(pruned.Removed), formatSize(pruned.Reclaimed)))
+	}
+
+	ui.PrintHeader("Installation Complete! 🎉")
+	return nil
+}
+
+// resolveName picks the name a source is installed under: the one given, or
+// the first name derived from the source that is free or already its own
+func resolveName(cfg *config.Config, inst *installer.Installer, source, name string) (string, error) {
+	repos, err := manifest.New(cfg).Load()
+	if err != nil {
+		return "", fmt.Errorf("failed to load manifest: %w", err)
+	}
+
+	if name != "" {
+		if err := installer.CheckName(name); err != nil {
+			return "", err
+		}
+		if repo, ok := repos[name]; ok && !installer.SameSource(repo.URL, source) {
+			return "", fmt.Errorf("%s is already installed from %s", name, repo.URL)
+		}
+		return name, nil
+	}
+
+	for _, candidate := range installer.RepoNames(source) {
+		if repo, ok := repos[candidate]; ok {
+			if installer.SameSource(repo.URL, source) {
+				return candidate, nil
+			}
+			continue
+		}
+		if cached, matches := inst.CacheMatches(candidate, source); cached && !matches {
+			continue
+		}
+		return candidate, nil
+	}
+
+	return "", fmt.Errorf("every name for %s is taken, choose one with --name", source)
+}
+
+// checkPlanFlags validates --dry-run and --json and keeps stdout free for a JSON plan
+func checkPlanFlags() error {
+	if asJSON && !dryRun {
+		return fmt.Errorf("--json requires --dry-run")
+	}
+
+	if asJSON {
+		ui.SetOutput(os.Stderr)
+	}
+
+	return nil
+}
+
+// lockState takes the state lock for a command that changes installed state,
+// so it never races another godotctl, and deals with an operation that an
+// earlier run left unfinished. The lock is held until the process exits.
+func lockState(cfg *config.Config) error {
+	if stateLock != nil {
+		return nil
+	}
+
+	lock, err := installer.AcquireLock(cfg, false)
+	var locked *installer.LockedError
+	if errors.As(err, &locked) && wait {
+		ui.PrintInfo(fmt.Sprintf("%s, waiting for it to finish...", locked))
+		lock, err = installer.AcquireLock(cfg, true)
+	}
+	if err != nil {
+		return err
+	}
+	stateLock = lock
+
+	// Storage that collides with the new location is left behind and reported
+	if err := cfg.Migrate(); err != nil {
+		var collision *config.CollisionError
+		if !errors.As(err, &collision) {
+			return err
+		}
+		ui.PrintWarning(err.Error())
+	}
+
+	return recoverInterrupted(cfg)
+}
+
+// recoverInterrupted offers to resume or roll back an operation that was
+// killed or crashed. With --auto it is rolled back.
+func recoverInterrupted(cfg *config.Config) error {
+	inst := installer.New(cfg)
+
+	journal, err := inst.Interrupted()
+	if err != nil || journal == nil {
+		return err
+	}
+
+	ui.PrintWarning(fmt.Sprintf("The %s of %s started %s (pid %d) was interrupted after %d changes",
+		journal.Operation, journal.Repo, journal.StartedAt.Format("2006-01-02 15:04"), journal.PID, len(journal.Steps)))
+
+	choice := ui.RecoverRollback
+	if !auto {
+		choice, err = ui.PromptRecovery(journal)
+		if err != nil {
+			return fmt.Errorf("the interrupted %s has to be resumed or rolled back first", journal.Operation)
+		}
+	}
+
+	switch choice {
+	case ui.RecoverResume:
+		return resume(journal)
+
+	case ui.RecoverRollback:
+		if err := inst.Resume(journal); err != nil {
+			return err
+		}
+		undone, err := inst.Rollback()
+		if err != nil {
+			return err
+		}
+		ui.PrintSuccess(fmt.Sprintf("Rolled back %d changes", undone))
+		return nil
+	}
+
+	return fmt.Errorf("the interrupted %s was left as it is", journal.Operation)
+}
+
+// resume runs the interrupted command again on top of its journal, so a
+// failure rolls back everything it did in either run. When that is not the
+// command being run now, the current command continues afterwards.
+func resume(journal *installer.Journal) error {
+	resuming = journal
+	if slices.Equal(journal.Command, os.Args[1:]) {
+		return nil
+	}
+
+	c, args, err := rootCmd.Find(journal.Command)
+	if err != nil || c.RunE == nil {
+		return fmt.Errorf("cannot resume %q", strings.Join(journal.Command, " "))
+	}
+
+	// Parse the recorded flags from their defaults and put the current ones back afterwards
+	flags := c.LocalNonPersistentFlags()
+	saved := make(map[string]string)
+	flags.VisitAll(func(f *pflag.Flag) {
+		saved[f.Name] = f.Value.String()
+		f.Value.Set(f.DefValue)
+	})
+	defer flags.VisitAll(func(f *pflag.Flag) {
+		f.Value.Set(saved[f.Name])
+	})
+
+	if err := c.ParseFlags(args); err != nil {
+		return err
+	}
+	if err := c.ValidateArgs(c.Flags().Args()); err != nil {
+		return err
+	}
+
+	ui.PrintInfo(fmt.Sprintf("Resuming godotctl %s", strings.Join(journal.Command, " ")))
+	if err := c.RunE(c, c.Flags().Args()); err != nil {
+		return fmt.Errorf("resumed %s failed: %w", journal.Operation, err)
+	}
+
+	return nil
+}
+
+// begin starts the transaction of an operation, continuing the journal of
+// the interrupted operation being resumed
+func begin(inst *installer.Installer, operation, repo string) error {
+	if journal := resuming; journal != nil {
+		resuming = nil
+		if err := inst.Resume(journal); err != nil {
+			return err
+		}
+		if journal.Operation == operation && journal.Repo == repo {
+			return nil
+		}
+
+		// The resumed command went on to something else, keep what was done
+		if err := inst.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return inst.Begin(operation, repo, os.Args[1:])
+}
+
+// rollback undoes the transaction in progress after err and returns err
+func rollback(inst *installer.Installer, err error) error {
+	undone, rbErr := inst.Rollback()
+	if rbErr != nil {
+		ui.PrintError(fmt.Sprintf("Rollback failed: %v", rbErr))
+		return err
+	}
+
+	if undone > 0 {
+		ui.PrintWarning(fmt.Sprintf("Rolled back %d changes", undone))
+	}
+	return err
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List installed dotfile repositories",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		man := manifest.New(cfg)
+		repos, err := man.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load manifest: %w", err)
+		}
+
+		if len(repos) == 0 {
+			ui.PrintInfo("No dotfiles installed")
+			return nil
+		}
+
+		ui.PrintHeader("Installed Dotfiles")
+		for name, repo := range repos {
+			fmt.Printf("\n📦 %s\n", name)
+			fmt.Printf("   URL: %s\n", repo.URL)
+			if repo.Revision != nil {
+				fmt.Printf("   Revision: %s\n", formatRevision(repo.Revision))
+			}
+			fmt.Printf("   Installed: %v\n", repo.InstalledAt.Format("2006-01-02 15:04"))
+			if !repo.LastUpdated.IsZero() {
+				fmt.Printf("   Updated: %v\n", repo.LastUpdated.Format("2006-01-02 15:04"))
+			}
+			fmt.Printf("   Groups: %v\n", repo.InstalledGroups)
+			if len(repo.SkippedGroups) > 0 {
+				fmt.Printf("   Skipped: %v\n", repo.SkippedGroups)
+			}
+		}
+
+		return nil
+	},
+}
+
+var updateCmd = &cobra.Command{
+	Use:   "update [repo-name]",
+	Short: "Update installed dotfiles",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkPlanFlags(); err != nil {
+			return err
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		if !dryRun {
+			if err := lockState(cfg); err != nil {
+				return err
+			}
+		}
+
+		man := manifest.New(cfg)
+		repos, err := man.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load manifest: %w", err)
+		}
+
+		if len(repos) == 0 {
+			ui.PrintInfo("No dotfiles installed")
+			return nil
+		}
+
+		// If specific repo provided, update only that one
+		if len(args) > 0 {
+			repoName := args[0]
+			if repo, exists := repos[repoName]; exists {
+				return updateRepo(cfg, repoName, repo)
+			}
+			return fmt.Errorf("repository '%s' not found", repoName)
+		}
+
+		// Update all repos
+		for name, repo := range repos {
+			if err := updateRepo(cfg, name, repo); err != nil {
+				ui.PrintWarning(fmt.Sprintf("Failed to update %s: %v", name, err))
+			}
+		}
+
+		return nil
+	},
+}
+
+func updateRepo(cfg *config.Config, name string, repo manifest.RepoConfig) (err error) {
+	ui.PrintInfo(fmt.Sprintf("Updating %s...", name))
+
+	inst := installer.New(cfg)
+
+	// Changes to the cache and links are undone if the update fails
+	if !dryRun {
+		if err := begin(inst, "update", name); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				err = rollback(inst, err)
+			}
+		}()
+	}
+
+	// Update cached repo based on source type, or only look at what it would
+	// pull. The pull is shown from the deployed commit, when it was recorded.
+	before := repo.Revision
+	if before == nil || before.Commit == "" {
+		before = installer.ReadRevision(repo.CachedAt)
+	}
+	repoPath := repo.CachedAt
+	var preview installer.Preview
+	if dryRun {
+		preview, err = inst.PreviewUpdate(repo.CachedAt, repo.SourceType)
+		if err != nil {
+			return err
+		}
+		defer preview.Cleanup()
+		repoPath = preview.Contents
+	} else if err := inst.Update(repo.CachedAt, repo.SourceType); err != nil {
+		return err
+	}
+
+	// Show what the pull brought in
+	revision := installer.ReadRevision(repoPath)
+	var pulled []string
+	if before != nil && revision != nil && before.Commit != revision.Commit {
+		if pulled, err = installer.ShortLog(repoPath, before.Commit, revision.Commit); err != nil {
+			ui.PrintWarning(fmt.Sprintf("Failed to read the log of %s: %v", name, err))
+		}
+		if !dryRun {
+			ui.PrintInfo(fmt.Sprintf("Pulled %d commits (%s..%s)", len(pulled), before.Short(), revision.Short()))
+			for _, commit := range pulled {
+				fmt.Printf("   %s\n", commit)
+			}
+		}
+	}
+
+	groups, err := inst.Scan(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to scan dotfiles: %w", err)
+	}
+
+	// Keep the strategy each group was installed with
+	for i, group := range groups {
+		if s, ok := repo.Strategies[group.Name]; ok {
+			groups[i].Strategy = s
+		}
+	}
+
+	if repo.Facts.Recorded() && !inst.Facts().Equal(repo.Facts) {
+		ui.PrintInfo("Machine facts changed, re-evaluating alternates...")
+	}
+
+	// Groups added to the repository since the last install or update.
+	// Manifests from before available groups were recorded only tell which
+	// groups were installed or skipped, so every other group is new.
+	seen := repo.AvailableGroups
+	if seen == nil {
+		seen = repo.SkippedGroups
+	}
+
+	var (
+		available []string
+		added     []installer.DotfileGroup
+	)
+	for _, group := range groups {
+		available = append(available, group.Name)
+		if !slices.Contains(seen, group.Name) && !slices.Contains(repo.InstalledGroups, group.Name) {
+			added = append(added, group)
+		}
+	}
+
+	// Plan the update on a copy of the manifest entry, then run it for real
+	var (
+		updated manifest.RepoConfig
+		synced  repoSync
+	)
+	plan, err := inst.Plan("update", name, func() (installer.LinkSet, error) {
+		updated = repo
+		updated.InstalledGroups = slices.Clone(repo.InstalledGroups)
+		updated.Variants = maps.Clone(repo.Variants)
+		updated.SetLinks(repo.Links().Clone())
+
+		synced, err = syncRepo(inst, &updated, groups)
+		return updated.Links(), err
+	})
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		plan.Fetch = preview.Fetch
+		plan.Commits = pulled
+		plan.Groups = updated.InstalledGroups
+		plan.Manifest = []string{fmt.Sprintf("record the links of %s", name)}
+		if len(synced.removed) > 0 {
+			plan.Manifest = append(plan.Manifest, fmt.Sprintf("forget groups %v, no longer in the repository", synced.removed))
+		}
+		if revision != nil {
+			plan.Manifest = append(plan.Manifest, fmt.Sprintf("record revision %s", formatRevision(revision)))
+		}
+		if len(added) > 0 {
+			var names []string
+			for _, group := range added {
+				names = append(names, group.Name)
+			}
+			plan.Manifest = append(plan.Manifest, fmt.Sprintf("offer new groups %v", names))
+		}
+		for _, conflict := range synced.blocked {
+			ui.PrintWarning(fmt.Sprintf("Not linking new files of %s: %s is in the way", conflict.Group, conflict.Path))
+		}
+		return ui.PrintPlan(plan, asJSON)
+	}
+
+	if _, err := inst.Execute(plan); err != nil {
+		return err
+	}
+	for _, path := range synced.modified {
+		ui.PrintWarning(fmt.Sprintf("Skipped %s: modified locally", path))
+	}
+	for _, conflict := range synced.blocked {
+		ui.PrintWarning(fmt.Sprintf("Not linking new files of %s: %s is in the way", conflict.Group, conflict.Path))
+	}
+	for _, group := range synced.removed {
+		ui.PrintInfo(fmt.Sprintf("Removed %s, which is no longer in the repository", group))
+	}
+	if synced.stale > 0 {
+		ui.PrintInfo(fmt.Sprintf("Removed %d links and copies the repository no longer provides", synced.stale))
+	}
+	for _, path := range synced.kept {
+		ui.PrintWarning(fmt.Sprintf("Keeping %s: modified locally", path))
+	}
+
+	updated.Revision = revision
+	updated.AvailableGroups = available
+	if auto {
+		// Only reported, so they are offered again by the next update
+		updated.AvailableGroups = slices.DeleteFunc(available, func(group string) bool {
+			return slices.ContainsFunc(added, func(a installer.DotfileGroup) bool { return a.Name == group })
+		})
+	}
+	man := manifest.New(cfg)
+	if err := man.UpdateRepo(name, updated); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	if err := inst.Commit(); err != nil {
+		ui.PrintWarning(err.Error())
+	}
+
+	ui.PrintSuccess(fmt.Sprintf("%s updated", name))
+
+	// The update is complete, so failing to add groups leaves it in place
+	if len(added) > 0 {
+		if err := offerGroups(cfg, name, updated.URL, added); err != nil {
+			ui.PrintWarning(fmt.Sprintf("Failed to add new groups of %s: %v", name, err))
+		}
+	}
+
+	return nil
+}
+
+// repoSync is what syncRepo did besides changing the links
+type repoSync struct {
+	modified []string             // Copies left alone because they were modified locally
+	kept     []string             // Copies no longer provided but modified locally
+	blocked  []installer.Conflict // Paths keeping new files of installed groups from being linked
+	removed  []string             // Installed groups the repository no longer has
+	stale    int                  // Links and copies removed
+}
+
+// syncRepo brings an installed repository in line with its rescanned groups.
+// Installed groups are linked again to pick up new files, and links and
+// copies the repository no longer provides are removed.
+func syncRepo(inst *installer.Installer, repo *manifest.RepoConfig, groups []installer.DotfileGroup) (repoSync, error) {
+	var result repoSync
+
+	// Re-evaluate alternates when the machine's facts changed since install;
+	// facts that were never recorded are only recorded now
+	facts := inst.Facts()
+	if repo.Facts.Recorded() && !facts.Equal(repo.Facts) {
+		if err := relinkAlternates(inst, repo, groups); err != nil {
+			return result, err
+		}
+	}
+	repo.Facts = facts
+
+	links := repo.Links()
+	for _, group := range groups {
+		if !slices.Contains(repo.InstalledGroups, group.Name) {
+			continue
+		}
+
+		// Refresh copies, keeping any the user edited in place
+		if group.Strategy == installer.StrategyCopy {
+			modified, err := inst.SyncCopies(group, &links)
+			if err != nil {
+				return result, fmt.Errorf("failed to update copies of %s: %w", group.Name, err)
+			}
+			result.modified = append(result.modified, modified...)
+			continue
+		}
+
+		// Link files added to the group and re-render templates, unless
+		// something godots does not manage is in the way
+		conflicts, err := inst.CheckConflicts([]installer.DotfileGroup{group})
+		if err != nil {
+			return result, fmt.Errorf("failed to check conflicts of %s: %w", group.Name, err)
+		}
+		if len(conflicts) > 0 {
+			result.blocked = append(result.blocked, conflicts...)
+			continue
+		}
+
+		created, err := inst.CreateSymlinks([]installer.DotfileGroup{group})
+		links.Merge(created)
+		if err != nil {
+			return result, fmt.Errorf("failed to link %s: %w", group.Name, err)
+		}
+	}
+
+	// Remove what the repository no longer provides
+	stale := inst.Stale(links, groups, repo.InstalledGroups)
+	result.kept = inst.ModifiedCopies(stale.Copies)
+	if err := inst.RemoveSymlinks(stale); err != nil {
+		return result, err
+	}
+	links.Remove(stale)
+	result.stale = len(stale.Symlinks) + len(stale.Copies)
+
+	repo.InstalledGroups = slices.DeleteFunc(repo.InstalledGroups, func(name string) bool {
+		if slices.ContainsFunc(groups, func(g installer.DotfileGroup) bool { return g.Name == name }) {
+			return false
+		}
+		result.removed = append(result.removed, name)
+		delete(repo.Variants, name)
+		return true
+	})
+	repo.SetLinks(links)
+
+	return result, nil
+}
+
+// offerGroups offers to install groups added to a repository since it was
+// last installed or updated. With --auto they are only reported.
+func offerGroups(cfg *config.Config, name, source string, added []installer.DotfileGroup) error {
+	var names []string
+	for _, group := range added {
+		names = append(names, group.Name)
+	}
+
+	if auto {
+		ui.PrintInfo(fmt.Sprintf("%s has new groups %v, add them with godotctl install %s", name, names, source))
+		return nil
+	}
+
+	ui.PrintInfo(fmt.Sprintf("%s has new groups %v", name, names))
+	selected, err := ui.PromptSelectGroups(added)
+	if err != nil {
+		return fmt.Errorf("selection cancelled: %w", err)
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+
+	return installSource(cfg, source, name, func(scanned []installer.DotfileGroup) ([]installer.DotfileGroup, error) {
+		var chosen []installer.DotfileGroup
+		for _, group := range scanned {
+			if slices.ContainsFunc(selected, func(s installer.DotfileGroup) bool { return s.Name == group.Name }) {
+				chosen = append(chosen, group)
+			}
+		}
+		return chosen, nil
+	})
+}
+
+// relinkAlternates relinks installed groups whose selected alternate changed
+func relinkAlternates(inst *installer.Installer, repo *manifest.RepoConfig, groups []installer.DotfileGroup) error {
+	if repo.Variants == nil {
+		repo.Variants = make(map[string]string)
+	}
+
+	links := repo.Links()
+	for _, group := range groups {
+		if !slices.Contains(repo.InstalledGroups, group.Name) {
+			continue
+		}
+
+		// Tree groups may hold alternates at any depth, so always relink them
+		if group.Variant == repo.Variants[group.Name] && group.Strategy != installer.StrategyTree {
+			continue
+		}
+
+		if err := inst.Relink(group, &links); err != nil {
+			return fmt.Errorf("failed to relink %s: %w", group.Name, err)
+		}
+
+		if group.Variant != "" {
+			repo.Variants[group.Name] = group.Variant
+		} else {
+			delete(repo.Variants, group.Name)
+		}
+	}
+	repo.SetLinks(links)
+
+	return nil
+}
+
+var uninstallCmd = &cobra.Command{
+	Use:   "uninstall [repo-name]",
+	Short: "Uninstall dotfiles repository",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		repoName := args[0]
+
+		if err := checkPlanFlags(); err != nil {
+			return err
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		if !dryRun {
+			if err := lockState(cfg); err != nil {
+				return err
+			}
+		}
+
+		man := manifest.New(cfg)
+		repos, err := man.Load()
+		if err != nil {
+			return err
+		}
+
+		repo, exists := repos[repoName]
+		if !exists {
+			return fmt.Errorf("repository '%s' not found", repoName)
+		}
+
+		inst := installer.New(cfg)
+
+		if len(groups) > 0 {
+			return uninstallGroups(inst, man, repoName, repo)
+		}
+
+		plan, err := inst.PlanUninstall(repoName, repo.Links(), repo.CachedAt)
+		if err != nil {
+			return err
+		}
+		plan.Groups = repo.InstalledGroups
+
+		if dryRun {
+			plan.Manifest = []string{fmt.Sprintf("remove %s", repoName)}
+			return ui.PrintPlan(plan, asJSON)
+		}
+
+		ui.PrintWarning(fmt.Sprintf("This will remove %d links and copies and the cached repository of %s", len(repo.Entries), repoName))
+
+		confirm, err := ui.PromptConfirm("Continue with uninstall?")
+		if err != nil || !confirm {
+			return fmt.Errorf("uninstall cancelled")
+		}
+
+		for _, path := range inst.ModifiedCopies(repo.Copies) {
+			ui.PrintWarning(fmt.Sprintf("Keeping %s: modified locally", path))
+		}
+
+		// Removed links, copies and the cache are put back if the uninstall fails
+		if err := begin(inst, "uninstall", repoName); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				err = rollback(inst, err)
+			}
+		}()
+
+		// Remove symlinks and the cached repo
+		ui.PrintInfo("Removing symlinks and cached repository...")
+		if _, err := inst.Execute(plan); err != nil {
+			return err
+		}
+
+		// Update manifest
+		if err := man.RemoveRepo(repoName); err != nil {
+			return err
+		}
+
+		if err := inst.Commit(); err != nil {
+			ui.PrintWarning(err.Error())
+		}
+
+		ui.PrintSuccess("Uninstalled successfully")
+		printBackups(inst, repo.Entries)
+		return nil
+	},
+}
+
+// printBackups points out the backups still holding what removed entries replaced
+func printBackups(inst *installer.Installer, entries map[string]installer.LinkEntry) {
+	for _, path := range slices.Sorted(maps.Keys(entries)) {
+		backup := entries[path].Backup
+		if backup == "" {
+			continue
+		}
+
+		index, err := inst.LoadBackup(backup)
+		if err != nil || !slices.ContainsFunc(index.Entries, func(e installer.BackupEntry) bool { return e.Original == path }) {
+			continue
+		}
+		ui.PrintInfo(fmt.Sprintf("The original %s is in backup %s: godotctl restore %s %s", path, backup, backup, path))
+	}
+}
+
+// uninstallGroups removes the groups named by --group, keeping the rest of the repository
+func uninstallGroups(inst *installer.Installer, man *manifest.Manager, repoName string, repo manifest.RepoConfig) (err error) {
+	for _, group := range groups {
+		if !slices.Contains(repo.InstalledGroups, group) {
+			return fmt.Errorf("group '%s' of %s is not installed", group, repoName)
+		}
+	}
+
+	plan, err := inst.PlanUninstallGroups(repoName, repo.Links(), groups)
+	if err != nil {
+		return err
+	}
+	plan.Groups = groups
+
+	if dryRun {
+		plan.Manifest = []string{fmt.Sprintf("remove groups %v from %s", groups, repoName)}
+		return ui.PrintPlan(plan, asJSON)
+	}
+
+	removed := repo.Links().Clone()
+	removed.Remove(plan.Links)
+	ui.PrintWarning(fmt.Sprintf("This will remove %d links and copies of %v from %s", len(removed.Entries), groups, repoName))
+
+	confirm, err := ui.PromptConfirm("Continue with uninstall?")
+	if err != nil || !confirm {
+		return fmt.Errorf("uninstall cancelled")
+	}
+
+	for _, path := range inst.ModifiedCopies(removed.Copies) {
+		ui.PrintWarning(fmt.Sprintf("Keeping %s: modified locally", path))
+	}
+
+	if err := begin(inst, "uninstall", repoName); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = rollback(inst, err)
+		}
+	}()
+
+	ui.PrintInfo("Removing links...")
+	links, err := inst.Execute(plan)
+	if err != nil {
+		return err
 	}
-}
 
-var rootCmd = &cobra.Command{
-	Use:   "godotctl",
-	Short: "A dotfiles installer for CachyOS",
-	Long:  `Manage your dotfiles with symlinks, backups, and hooks.`,
-}
+	repo.SetLinks(links)
+	repo.InstalledGroups = slices.DeleteFunc(repo.InstalledGroups, func(name string) bool {
+		return slices.Contains(groups, name)
+	})
+	for _, group := range groups {
+		delete(repo.Variants, group)
+	}
+	if err := man.SetRepo(repoName, repo); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
 
-var installCmd = &cobra.Command{
-	Use:   "install [repository-url-or-path]",
-	Short: "Install dotfiles from a git repository or local directory",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		source := args[0]
+	if err := inst.Commit(); err != nil {
+		ui.PrintWarning(err.Error())
+	}
+
+	ui.PrintSuccess(fmt.Sprintf("Uninstalled %v", groups))
+	printBackups(inst, removed.Entries)
+	return nil
+}
 
-		ui.PrintHeader("Installing Dotfiles")
-		ui.PrintInfo(fmt.Sprintf("Source: %s", source))
+var adoptCmd = &cobra.Command{
+	Use:   "adopt [repo-name] [path...]",
+	Short: "Move existing configs into an installed repository and link them back",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		repoName := args[0]
 
-		// Initialize configuration
-		cfg, err := config.New()
+		cfg, err := loadConfig()
 		if err != nil {
-			return fmt.Errorf("failed to initialize config: %w", err)
+			return err
 		}
 
-		// Initialize installer
-		inst := installer.New(cfg)
+		if err := lockState(cfg); err != nil {
+			return err
+		}
 
-		// Clone/copy repository
-		ui.PrintInfo("Preparing repository...")
-		repoPath, repoName, sourceType, err := inst.Clone(source)
+		man := manifest.New(cfg)
+		repos, err := man.Load()
 		if err != nil {
-			return fmt.Errorf("failed to prepare repository: %w", err)
+			return fmt.Errorf("failed to load manifest: %w", err)
 		}
-		ui.PrintSuccess(fmt.Sprintf("Prepared at %s (type: %s)", repoPath, sourceType))
 
-		// Scan dotfiles structure
-		ui.PrintInfo("Scanning dotfiles...")
-		groups, err := inst.Scan(repoPath)
-		if err != nil {
-			return fmt.Errorf("failed to scan dotfiles: %w", err)
+		repo, exists := repos[repoName]
+		if !exists {
+			return fmt.Errorf("repository '%s' not found", repoName)
 		}
-		ui.PrintSuccess(fmt.Sprintf("Found %d configuration groups", len(groups)))
 
-		// Show selection prompt (unless auto mode)
-		var selectedGroups []installer.DotfileGroup
-		if auto {
-			selectedGroups = groups
-		} else {
-			selectedGroups, err = ui.PromptSelectGroups(groups)
+		inst := installer.New(cfg)
+		links := repo.Links()
+
+		// Every path is moved back and unlinked if any of them fails
+		if err := begin(inst, "adopt", repoName); err != nil {
+			return err
+		}
+		defer func() {
 			if err != nil {
-				return fmt.Errorf("selection cancelled: %w", err)
+				err = rollback(inst, err)
+			}
+		}()
+
+		var sources []string
+		for _, arg := range args[1:] {
+			path, err := filepath.Abs(arg)
+			if err != nil {
+				return err
+			}
+
+			group, adopted, err := inst.AdoptPath(repo.CachedAt, path)
+			if err != nil {
+				return fmt.Errorf("failed to adopt %s: %w", path, err)
+			}
+			links.Merge(adopted)
+
+			if !slices.Contains(repo.InstalledGroups, group.Name) {
+				repo.InstalledGroups = append(repo.InstalledGroups, group.Name)
 			}
+			repo.SkippedGroups = slices.DeleteFunc(repo.SkippedGroups, func(name string) bool {
+				return name == group.Name
+			})
+
+			sources = append(sources, group.Source)
+			ui.PrintSuccess(fmt.Sprintf("Adopted %s into %s", path, group.Source))
 		}
 
-		if len(selectedGroups) == 0 {
-			ui.PrintInfo("No groups selected, exiting")
-			return nil
+		repo.SetLinks(links)
+		if err := man.SetRepo(repoName, repo); err != nil {
+			return fmt.Errorf("failed to save manifest: %w", err)
+		}
+
+		if err := inst.Commit(); err != nil {
+			ui.PrintWarning(err.Error())
+		}
+
+		if commit {
+			ui.PrintInfo("Committing adopted files...")
+			if err := inst.CommitAdopted(repo.CachedAt, sources, fmt.Sprintf("Adopt %d paths with godotctl", len(sources))); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+var backupsCmd = &cobra.Command{
+	Use:   "backups",
+	Short: "Inspect backups of files replaced during installs",
+}
+
+var backupsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List backups",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
 		}
 
-		// Check for conflicts and backup
-		ui.PrintInfo("Checking for existing files...")
-		conflicts, err := inst.CheckConflicts(selectedGroups)
+		backups, err := installer.New(cfg).Backups()
 		if err != nil {
-			return fmt.Errorf("failed to check conflicts: %w", err)
+			return fmt.Errorf("failed to read backups: %w", err)
 		}
 
-		if len(conflicts) > 0 {
-			ui.PrintWarning(fmt.Sprintf("Found %d existing files", len(conflicts)))
+		if len(backups) == 0 {
+			ui.PrintInfo("No backups")
+			return nil
+		}
 
-			if !auto {
-				confirm, err := ui.PromptConfirm("Backup existing files and continue?")
-				if err != nil || !confirm {
-					return fmt.Errorf("installation cancelled")
-				}
+		ui.PrintHeader("Backups")
+		for _, backup := range backups {
+			fmt.Printf("\n🗄  %s\n", backup.ID)
+			fmt.Printf("   Repo: %s (%s)\n", backup.Repo, backup.Operation)
+			fmt.Printf("   Created: %v\n", backup.CreatedAt.Format("2006-01-02 15:04"))
+			if backup.Store == installer.StoreBlobs {
+				fmt.Printf("   Store: %s\n", backup.Store)
 			}
-
-			ui.PrintInfo("Backing up existing files...")
-			backupDir, err := inst.Backup(conflicts)
-			if err != nil {
-				return fmt.Errorf("backup failed: %w", err)
+			for _, entry := range backup.Entries {
+				fmt.Printf("   %s %s (%s)\n", entry.Mode, entry.Original, entry.Type)
 			}
-			ui.PrintSuccess(fmt.Sprintf("Backed up to %s", backupDir))
 		}
 
-		// Create symlinks
-		ui.PrintInfo("Creating symlinks...")
-		symlinks, err := inst.CreateSymlinks(selectedGroups)
+		return nil
+	},
+}
+
+var backupsVerifyCmd = &cobra.Command{
+	Use:   "verify [backup-id...]",
+	Short: "Check backups against the checksums recorded when they were taken",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
 		if err != nil {
-			return fmt.Errorf("failed to create symlinks: %w", err)
+			return err
 		}
-		ui.PrintSuccess(fmt.Sprintf("Created %d symlinks", len(symlinks)))
 
-		// Discover hooks
-		hooks, err := inst.DiscoverHooks(repoPath)
-		if err != nil {
-			ui.PrintWarning(fmt.Sprintf("Failed to discover hooks: %v", err))
-		} else if len(hooks) > 0 {
-			ui.PrintInfo(fmt.Sprintf("Found %d post-install hooks", len(hooks)))
+		inst := installer.New(cfg)
 
-			if !auto {
-				runHooks, err := ui.PromptConfirm("Run post-install hooks?")
-				if err == nil && runHooks {
-					if err := inst.RunHooks(hooks, false); err != nil {
-						ui.PrintWarning(fmt.Sprintf("Some hooks failed: %v", err))
-					}
-				}
-			} else {
-				if err := inst.RunHooks(hooks, true); err != nil {
-					ui.PrintWarning(fmt.Sprintf("Some hooks failed: %v", err))
-				}
+		// Verify everything when no backup is named
+		ids := args
+		if len(ids) == 0 {
+			backups, err := inst.Backups()
+			if err != nil {
+				return fmt.Errorf("failed to read backups: %w", err)
+			}
+			for _, backup := range backups {
+				ids = append(ids, backup.ID)
 			}
 		}
 
-		// Save manifest
-		ui.PrintInfo("Saving installation manifest...")
-		man := manifest.New(cfg.ManifestPath)
-		if err := man.AddRepo(repoName, source, repoPath, sourceType, selectedGroups, symlinks); err != nil {
-			return fmt.Errorf("failed to save manifest: %w", err)
+		damaged := 0
+		for _, id := range ids {
+			problems, err := inst.VerifyBackup(id)
+			if err != nil {
+				return fmt.Errorf("failed to verify %s: %w", id, err)
+			}
+
+			if len(problems) == 0 {
+				ui.PrintSuccess(fmt.Sprintf("%s is intact", id))
+				continue
+			}
+
+			damaged++
+			for _, problem := range problems {
+				ui.PrintError(fmt.Sprintf("%s: %s", id, problem))
+			}
+		}
+
+		if damaged > 0 {
+			return fmt.Errorf("%d backups failed verification", damaged)
 		}
-		ui.PrintSuccess("Manifest saved")
 
-		ui.PrintHeader("Installation Complete! 🎉")
 		return nil
 	},
 }
 
-var listCmd = &cobra.Command{
-	Use:   "list",
-	Short: "List installed dotfile repositories",
+var backupsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove backups not kept by the retention policy",
+	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.New()
+		cfg, err := loadConfig()
 		if err != nil {
 			return err
 		}
 
-		man := manifest.New(cfg.ManifestPath)
-		repos, err := man.Load()
+		if !cfg.Settings.Backups.Retains() {
+			ui.PrintInfo("No retention policy configured, keeping all backups")
+			return nil
+		}
+
+		if !dryRun {
+			if err := lockState(cfg); err != nil {
+				return err
+			}
+		}
+
+		result, err := installer.New(cfg).PruneBackups(dryRun)
 		if err != nil {
-			return fmt.Errorf("failed to load manifest: %w", err)
+			return fmt.Errorf("failed to prune backups: %w", err)
 		}
 
-		if len(repos) == 0 {
-			ui.PrintInfo("No dotfiles installed")
+		if len(result.Removed) == 0 {
+			ui.PrintInfo("Nothing to prune")
 			return nil
 		}
 
-		ui.PrintHeader("Installed Dotfiles")
-		for name, repo := range repos {
-			fmt.Printf("\n📦 %s\n", name)
-			fmt.Printf("   URL: %s\n", repo.URL)
-			fmt.Printf("   Installed: %v\n", repo.InstalledAt.Format("2006-01-02 15:04"))
-			fmt.Printf("   Groups: %v\n", repo.InstalledGroups)
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		for _, backup := range result.Removed {
+			fmt.Printf("   %s %s (%s)\n", verb, backup.ID, backup.Repo)
+		}
+
+		if dryRun {
+			ui.PrintInfo(fmt.Sprintf("Would reclaim %s from %d backups", formatSize(result.Reclaimed), len(result.Removed)))
+		} else {
+			ui.PrintSuccess(fmt.Sprintf("Reclaimed %s from %d backups", formatSize(result.Reclaimed), len(result.Removed)))
 		}
 
 		return nil
 	},
 }
 
-var updateCmd = &cobra.Command{
-	Use:   "update [repo-name]",
-	Short: "Update installed dotfiles",
+// formatRevision renders a revision as its short commit, branch and commit date
+func formatRevision(revision *installer.Revision) string {
+	s := revision.Short()
+	if revision.Branch != "" {
+		s += " on " + revision.Branch
+	}
+	if !revision.Date.IsZero() {
+		s += revision.Date.Format(" (2006-01-02 15:04)")
+	}
+	return s
+}
+
+// formatSize renders a byte count for humans
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [backup-id] [path...]",
+	Short: "Put backed up files back in place of the links that replaced them",
+	Args:  cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.New()
+		cfg, err := loadConfig()
 		if err != nil {
 			return err
 		}
 
-		man := manifest.New(cfg.ManifestPath)
-		repos, err := man.Load()
+		if err := lockState(cfg); err != nil {
+			return err
+		}
+
+		var paths []string
+		for _, arg := range args[1:] {
+			path, err := filepath.Abs(arg)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+		}
+
+		// Copies godots wrote may be replaced as long as nobody changed them
+		repos, err := manifest.New(cfg).Load()
 		if err != nil {
 			return fmt.Errorf("failed to load manifest: %w", err)
 		}
+		copies := make(map[string]string)
+		for _, repo := range repos {
+			maps.Copy(copies, repo.Copies)
+		}
 
-		if len(repos) == 0 {
-			ui.PrintInfo("No dotfiles installed")
-			return nil
+		inst := installer.New(cfg)
+		restored, restoreErr := inst.Restore(args[0], paths, copies)
+		for _, path := range restored {
+			ui.PrintSuccess(fmt.Sprintf("Restored %s", path))
 		}
 
-		// If specific repo provided, update only that one
-		if len(args) > 0 {
-			repoName := args[0]
-			if repo, exists := repos[repoName]; exists {
-				return updateRepo(cfg, repoName, repo)
+		// Record even a partial restore so the manifest matches the disk
+		if len(restored) > 0 {
+			if err := forgetRestored(cfg, inst, restored); err != nil {
+				return fmt.Errorf("failed to update manifest: %w", err)
 			}
-			return fmt.Errorf("repository '%s' not found", repoName)
 		}
 
-		// Update all repos
-		for name, repo := range repos {
-			if err := updateRepo(cfg, name, repo); err != nil {
-				ui.PrintWarning(fmt.Sprintf("Failed to update %s: %v", name, err))
-			}
+		if restoreErr != nil {
+			return restoreErr
+		}
+		if len(restored) == 0 {
+			ui.PrintInfo("Nothing to restore")
 		}
 
 		return nil
 	},
 }
 
-func updateRepo(cfg *config.Config, name string, repo manifest.RepoConfig) error {
-	ui.PrintInfo(fmt.Sprintf("Updating %s...", name))
+// forgetRestored drops links replaced by restored files from the manifest.
+// Groups whose whole target was restored are no longer installed.
+func forgetRestored(cfg *config.Config, inst *installer.Installer, restored []string) error {
+	man := manifest.New(cfg)
+	repos, err := man.Load()
+	if err != nil {
+		return err
+	}
 
-	inst := installer.New(cfg)
+	for name, repo := range repos {
+		links := repo.Links()
+		changed := false
+		for _, path := range restored {
+			subset := links.Subset(path)
+			if len(subset.Symlinks) > 0 || len(subset.Dirs) > 0 || len(subset.Unfolded) > 0 || len(subset.Copies) > 0 {
+				links.Remove(subset)
+				changed = true
+			}
+		}
 
-	// Update cached repo based on source type
-	if err := inst.Update(repo.CachedAt, repo.SourceType); err != nil {
-		return err
+		groups, err := inst.Scan(repo.CachedAt)
+		if err != nil {
+			ui.PrintWarning(fmt.Sprintf("Failed to scan %s: %v", name, err))
+			groups = nil
+		}
+
+		for _, group := range groups {
+			if !slices.Contains(repo.InstalledGroups, group.Name) {
+				continue
+			}
+			for _, path := range restored {
+				if group.Target == path || strings.HasPrefix(group.Target, path+string(filepath.Separator)) {
+					repo.InstalledGroups = slices.DeleteFunc(repo.InstalledGroups, func(g string) bool { return g == group.Name })
+					repo.SkippedGroups = append(repo.SkippedGroups, group.Name)
+					changed = true
+					break
+				}
+			}
+		}
+
+		if !changed {
+			continue
+		}
+
+		repo.SetLinks(links)
+		if err := man.SetRepo(name, repo); err != nil {
+			return err
+		}
 	}
 
-	ui.PrintSuccess(fmt.Sprintf("%s updated", name))
 	return nil
 }
 
-var uninstallCmd = &cobra.Command{
-	Use:   "uninstall [repo-name]",
-	Short: "Uninstall dotfiles repository",
-	Args:  cobra.ExactArgs(1),
+var exportLockCmd = &cobra.Command{
+	Use:   "export-lock [file]",
+	Short: "Write a lockfile pinning the installed repositories and groups",
+	Long:  `Write a lockfile that apply uses to set up the same repositories, commits and groups on another machine. Without a file the lockfile is printed.`,
+	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		repoName := args[0]
+		// Keep stdout for the lockfile itself
+		if len(args) == 0 {
+			ui.SetOutput(os.Stderr)
+		}
 
-		cfg, err := config.New()
+		cfg, err := loadConfig()
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to initialize config: %w", err)
 		}
 
-		man := manifest.New(cfg.ManifestPath)
+		man := manifest.New(cfg)
 		repos, err := man.Load()
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to load manifest: %w", err)
 		}
 
-		repo, exists := repos[repoName]
-		if !exists {
-			return fmt.Errorf("repository '%s' not found", repoName)
-		}
+		lock := manifest.Lockfile{Version: manifest.LockfileVersion}
+		inst := installer.New(cfg)
+		for _, name := range slices.Sorted(maps.Keys(repos)) {
+			repo := repos[name]
 
-		ui.PrintWarning(fmt.Sprintf("This will remove %d symlinks from %s", len(repo.Symlinks), repoName))
+			// Targets come from the cache, which holds the deployed commit
+			scanned, err := inst.Scan(repo.CachedAt)
+			if err != nil {
+				return fmt.Errorf("failed to scan %s: %w", name, err)
+			}
 
-		confirm, err := ui.PromptConfirm("Continue with uninstall?")
-		if err != nil || !confirm {
-			return fmt.Errorf("uninstall cancelled")
+			var installed []installer.DotfileGroup
+			for _, group := range repo.InstalledGroups {
+				idx := slices.IndexFunc(scanned, func(g installer.DotfileGroup) bool { return g.Name == group })
+				if idx < 0 {
+					ui.PrintWarning(fmt.Sprintf("%s is no longer in %s, leaving it out", group, name))
+					continue
+				}
+				installed = append(installed, scanned[idx])
+			}
+
+			if repo.Revision == nil && repo.SourceType != installer.SourceTypeLocalDir {
+				ui.PrintWarning(fmt.Sprintf("No revision is recorded for %s, it will not be pinned", name))
+			}
+
+			lock.Repos = append(lock.Repos, manifest.LockRepo(name, repo, installed, cfg.HomeDir, cfg.RenderDir))
 		}
 
-		inst := installer.New(cfg)
+		if len(args) == 0 {
+			return lock.Encode(os.Stdout)
+		}
+
+		if err := lock.Save(args[0]); err != nil {
+			return fmt.Errorf("failed to write lockfile: %w", err)
+		}
+		ui.PrintSuccess(fmt.Sprintf("Locked %d repositories in %s", len(lock.Repos), args[0]))
+		return nil
+	},
+}
 
-		// Remove symlinks
-		ui.PrintInfo("Removing symlinks...")
-		if err := inst.RemoveSymlinks(repo.Symlinks); err != nil {
+var applyCmd = &cobra.Command{
+	Use:   "apply [lockfile]",
+	Short: "Install the repositories and groups pinned in a lockfile",
+	Long:  `Clone each repository of a lockfile, check out its pinned commit and install exactly the locked groups without prompting.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		lock, err := manifest.ReadLockfile(args[0])
+		if err != nil {
 			return err
 		}
 
-		// Remove cached repo
-		ui.PrintInfo("Removing cached repository...")
-		if err := os.RemoveAll(repo.CachedAt); err != nil {
-			ui.PrintWarning(fmt.Sprintf("Failed to remove cache: %v", err))
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to initialize config: %w", err)
 		}
 
-		// Update manifest
-		if err := man.RemoveRepo(repoName); err != nil {
+		if err := lockState(cfg); err != nil {
 			return err
 		}
 
-		ui.PrintSuccess("Uninstalled successfully")
+		// Conflicts are backed up and hooks run, as with install --auto
+		auto = true
+
+		for _, locked := range lock.Repos {
+			if err := applyRepo(cfg, locked); err != nil {
+				return fmt.Errorf("failed to apply %s: %w", locked.Name, err)
+			}
+		}
+
 		return nil
 	},
 }
 
+// applyRepo installs one repository of a lockfile at its pinned commit
+func applyRepo(cfg *config.Config, locked manifest.LockedRepo) error {
+	inst := installer.New(cfg)
+
+	name, err := resolveName(cfg, inst, locked.URL, locked.Name)
+	if err != nil {
+		return err
+	}
+
+	ui.PrintInfo(fmt.Sprintf("Preparing %s...", name))
+	repoPath, repoName, _, err := inst.Clone(locked.URL, name)
+	if err != nil {
+		return fmt.Errorf("failed to prepare repository: %w", err)
+	}
+
+	if locked.Commit != "" {
+		if err := installer.Checkout(repoPath, locked.Commit, locked.Branch); err != nil {
+			return err
+		}
+		ui.PrintSuccess(fmt.Sprintf("Checked out %s", locked.Commit[:min(7, len(locked.Commit))]))
+	}
+
+	err = installSource(cfg, locked.URL, repoName, func(scanned []installer.DotfileGroup) ([]installer.DotfileGroup, error) {
+		var selected []installer.DotfileGroup
+		for _, group := range locked.Groups {
+			idx := slices.IndexFunc(scanned, func(g installer.DotfileGroup) bool { return g.Name == group.Name })
+			if idx < 0 {
+				return nil, fmt.Errorf("group %s is not in the repository", group.Name)
+			}
+
+			g := scanned[idx]
+			if group.Strategy != "" {
+				g.Strategy = group.Strategy
+			}
+			if group.Target != "" {
+				g.Target = manifest.ExpandHome(cfg.HomeDir, group.Target)
+			}
+			selected = append(selected, g)
+		}
+		return selected, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Report links the lockfile expects that this machine did not get
+	repos, err := manifest.New(cfg).Load()
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %w", err)
+	}
+	for _, target := range locked.Missing(cfg.HomeDir, repos[repoName]) {
+		ui.PrintWarning(fmt.Sprintf("%s is in the lockfile but was not created", target))
+	}
+
+	return nil
+}
+
 var setupHookCmd = &cobra.Command{
 	Use:   "setup-hook",
 	Short: "Install pacman hook for auto-updates",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.New()
+		cfg, err := loadConfig()
 		if err != nil {
 			return err
 		}
@@ -318,12 +1707,44 @@ var versionCmd = &cobra.Command{
 }
 
 func init() {
+	manifest.SetWarn(ui.PrintWarning)
+
+	rootCmd.PersistentFlags().StringVar(&homeDir, "home", "", "Home directory to operate on (default $GODOTS_HOME or your home)")
+	rootCmd.PersistentFlags().StringVar(&rootDir, "root", "", "Alternate root directory, e.g. a chroot or image being built")
+
 	rootCmd.AddCommand(installCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(updateCmd)
 	rootCmd.AddCommand(uninstallCmd)
+	rootCmd.AddCommand(adoptCmd)
+	rootCmd.AddCommand(backupsCmd)
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(exportLockCmd)
+	rootCmd.AddCommand(applyCmd)
 	rootCmd.AddCommand(setupHookCmd)
 	rootCmd.AddCommand(versionCmd)
 
 	installCmd.Flags().BoolVar(&auto, "auto", false, "Automatic mode (no prompts)")
+	installCmd.Flags().StringVar(&strategy, "strategy", "", "Default install strategy: symlink, tree or copy")
+	updateCmd.Flags().BoolVar(&auto, "auto", false, "Only report groups added to a repository instead of offering them")
+	installCmd.Flags().StringVar(&installName, "name", "", "Name to install the repository under (default derived from the source)")
+
+	for _, c := range []*cobra.Command{installCmd, updateCmd, uninstallCmd} {
+		c.Flags().BoolVar(&dryRun, "dry-run", false, "Show the plan without changing anything")
+		c.Flags().BoolVar(&asJSON, "json", false, "Print the dry-run plan as JSON")
+	}
+
+	uninstallCmd.Flags().StringSliceVar(&groups, "group", nil, "Only uninstall these groups, keeping the repository")
+
+	backupsCmd.AddCommand(backupsListCmd)
+	backupsCmd.AddCommand(backupsVerifyCmd)
+	backupsCmd.AddCommand(backupsPruneCmd)
+
+	backupsPruneCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be removed without removing it")
+
+	adoptCmd.Flags().BoolVar(&commit, "commit", false, "Commit the adopted files in the cached repository")
+
+	for _, c := range []*cobra.Command{installCmd, updateCmd, uninstallCmd, adoptCmd, restoreCmd, backupsPruneCmd, applyCmd} {
+		c.Flags().BoolVar(&wait, "wait", false, "Wait for another running godotctl instead of failing")
+	}
 }
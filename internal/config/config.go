@@ -3,39 +3,202 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
+	Root         string // Directory standing in for /, empty for the real root
 	HomeDir      string
 	CacheDir     string
 	ConfigDir    string
 	ManifestPath string
 	BackupDir    string
+	BlobDir      string
+	JournalDir   string
+	LockPath     string
+	DataDir      string
+	RenderDir    string
+	DataFile     string
+	StateDir     string
+	LogDir       string
+	Settings     Settings
+
+	// XDG base directories, used as default targets for config/ and local/
+	ConfigHome string
+	CacheHome  string
+	DataHome   string
+	StateHome  string
+}
+
+// Settings holds user preferences read from config.toml in the config directory
+type Settings struct {
+	Classes []string       `toml:"classes"`
+	Backups BackupSettings `toml:"backups"`
+	Hooks   HookSettings   `toml:"hooks"`
+}
+
+// HookSettings controls how post-install hook failures are handled
+type HookSettings struct {
+	RollbackOnFailure bool `toml:"rollback_on_failure"` // Undo the install when a hook fails
+}
+
+// BackupSettings is the retention policy for backups. A backup is kept when
+// any rule keeps it; with no rules set every backup is kept.
+type BackupSettings struct {
+	KeepLast    int    `toml:"keep_last"`     // Newest N backups
+	KeepWithin  string `toml:"keep_within"`   // Backups younger than this, e.g. "720h" or "30d"
+	KeepPerRepo int    `toml:"keep_per_repo"` // Newest N backups of each repository, at least 1 once any rule is set
+
+	// Store is "dir" for plain copies (the default) or "blobs" for the
+	// deduplicated, compressed store
+	Store string `toml:"store"`
+}
+
+// Retains reports whether a retention policy is configured
+func (s BackupSettings) Retains() bool {
+	return s.KeepLast > 0 || s.KeepWithin != "" || s.KeepPerRepo > 0
+}
+
+// Options redirect godots away from the invoking user's home directory
+type Options struct {
+	Home string // Home directory to install into, overrides GODOTS_HOME
+	Root string // Alternate root directory that every path is placed under
 }
 
-func New() (*Config, error) {
-	homeDir, err := os.UserHomeDir()
+func New(opts Options) (*Config, error) {
+	homeDir, overridden, err := resolveHome(opts.Home)
 	if err != nil {
 		return nil, err
 	}
 
-	cacheDir := filepath.Join(homeDir, ".cache", "godots")
-	configDir := filepath.Join(homeDir, ".config", "godots")
+	// The caller's XDG variables describe their own home, not an overridden one
+	xdg := xdgDir
+	if overridden {
+		xdg = func(_, fallback string) string { return fallback }
+	}
+
+	configHome := xdg("XDG_CONFIG_HOME", filepath.Join(homeDir, ".config"))
+	cacheHome := xdg("XDG_CACHE_HOME", filepath.Join(homeDir, ".cache"))
+	dataHome := xdg("XDG_DATA_HOME", filepath.Join(homeDir, ".local", "share"))
+	stateHome := xdg("XDG_STATE_HOME", filepath.Join(homeDir, ".local", "state"))
+
+	// Place everything under the alternate root
+	var root string
+	if opts.Root != "" {
+		if root, err = filepath.Abs(opts.Root); err != nil {
+			return nil, err
+		}
+		for _, dir := range []*string{&homeDir, &configHome, &cacheHome, &dataHome, &stateHome} {
+			*dir = filepath.Join(root, *dir)
+		}
+	}
+
+	cacheDir := filepath.Join(cacheHome, "godots")
+	configDir := filepath.Join(configHome, "godots")
 	manifestPath := filepath.Join(configDir, "manifest.toml")
-	backupDir := filepath.Join(homeDir, ".godots.backup")
+	dataDir := filepath.Join(dataHome, "godots")
+	stateDir := filepath.Join(stateHome, "godots")
+	backupDir := filepath.Join(stateDir, "backups")
+	blobDir := filepath.Join(stateDir, "blobs")
+	journalDir := filepath.Join(stateDir, "journal")
+	logDir := filepath.Join(stateDir, "logs")
 
 	// Ensure directories exist
-	for _, dir := range []string{cacheDir, configDir, backupDir} {
+	for _, dir := range []string{cacheDir, configDir, backupDir, dataDir, logDir} {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return nil, err
 		}
 	}
 
+	settings, err := loadSettings(filepath.Join(configDir, "config.toml"))
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
+		Root:         root,
 		HomeDir:      homeDir,
 		CacheDir:     cacheDir,
 		ConfigDir:    configDir,
 		ManifestPath: manifestPath,
 		BackupDir:    backupDir,
+		BlobDir:      blobDir,
+		JournalDir:   journalDir,
+		LockPath:     filepath.Join(stateDir, "lock"),
+		DataDir:      dataDir,
+		RenderDir:    filepath.Join(dataDir, "rendered"),
+		DataFile:     filepath.Join(configDir, "data.toml"),
+		StateDir:     stateDir,
+		LogDir:       logDir,
+		Settings:     settings,
+		ConfigHome:   configHome,
+		CacheHome:    cacheHome,
+		DataHome:     dataHome,
+		StateHome:    stateHome,
 	}, nil
 }
+
+// resolveHome picks the home directory from the flag, GODOTS_HOME or the
+// current user, reporting whether it was overridden
+func resolveHome(flag string) (string, bool, error) {
+	home := flag
+	if home == "" {
+		home = os.Getenv("GODOTS_HOME")
+	}
+
+	if home == "" {
+		home, err := os.UserHomeDir()
+		return home, false, err
+	}
+
+	home, err := filepath.Abs(home)
+	return home, true, err
+}
+
+// Unroot converts a path on this system into the path seen from inside Root
+func (c *Config) Unroot(path string) string {
+	if c.Root == "" {
+		return path
+	}
+
+	rel, err := filepath.Rel(c.Root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+
+	return filepath.Join(string(filepath.Separator), rel)
+}
+
+// Reroot converts an absolute path seen from inside Root into a path on this system
+func (c *Config) Reroot(path string) string {
+	if c.Root == "" || !filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(c.Root, path)
+}
+
+// xdgDir returns the directory named by an XDG variable. The spec requires
+// absolute paths, so relative values are ignored like unset ones.
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	return fallback
+}
+
+func loadSettings(path string) (Settings, error) {
+	var settings Settings
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return settings, nil
+	}
+
+	if _, err := toml.DecodeFile(path, &settings); err != nil {
+		return settings, err
+	}
+
+	return settings, nil
+}
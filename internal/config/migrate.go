@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storageMove is an old location of godots' storage and where it belongs now
+type storageMove struct {
+	from, to string
+	keepLink bool
+}
+
+// storageMoves lists the pre-XDG locations of godots' own storage. The cache
+// and data directories are referenced by installed symlinks and the
+// manifest, so a symlink is left at their old location.
+func (c *Config) storageMoves() []storageMove {
+	return []storageMove{
+		{filepath.Join(c.HomeDir, ".config", "godots"), c.ConfigDir, false},
+		{filepath.Join(c.HomeDir, ".cache", "godots"), c.CacheDir, true},
+		{filepath.Join(c.HomeDir, ".local", "share", "godots"), c.DataDir, true},
+		{filepath.Join(c.HomeDir, ".godots.backup"), c.BackupDir, false},
+	}
+}
+
+// CollisionError reports entries of an old storage directory that were left
+// there because the new one already has an entry of the same name
+type CollisionError struct {
+	From, To string
+	Names    []string
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("%s and %s both hold %s; move or remove one of each to finish migrating",
+		e.From, e.To, strings.Join(e.Names, ", "))
+}
+
+// UnmigratedDirs returns the pre-XDG locations holding storage Migrate would
+// move. Entries that collide with the new location are not counted, as
+// migrating again cannot move them.
+func (c *Config) UnmigratedDirs() []string {
+	var dirs []string
+	for _, move := range c.storageMoves() {
+		if len(unmigrated(move.from, move.to)) > 0 {
+			dirs = append(dirs, move.from)
+		}
+	}
+	return dirs
+}
+
+// unmigrated returns the entries of from that migrateDir would move to to,
+// or from itself when all that is left is to remove it
+func unmigrated(from, to string) []string {
+	info, err := os.Lstat(from)
+	if err != nil || !info.IsDir() || from == to {
+		return nil
+	}
+
+	entries, err := os.ReadDir(from)
+	if err != nil || len(entries) == 0 {
+		return []string{from}
+	}
+
+	var pending []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if migratedLink(from, to, name) {
+			continue
+		}
+		if exists(filepath.Join(to, name)) {
+			continue
+		}
+		pending = append(pending, filepath.Join(from, name))
+	}
+
+	return pending
+}
+
+// Migrate moves godots' own storage from the pre-XDG locations to the
+// current ones and reloads the settings. Callers hold the state lock, as
+// directories other processes may be using are renamed. Entries that collide
+// with the new location are left in place and reported as CollisionErrors
+// once everything else was moved.
+func (c *Config) Migrate() error {
+	var collisions []error
+	for _, move := range c.storageMoves() {
+		err := migrateDir(move.from, move.to, move.keepLink)
+		var collision *CollisionError
+		if errors.As(err, &collision) {
+			collisions = append(collisions, err)
+		} else if err != nil {
+			return fmt.Errorf("failed to migrate %s to %s: %w", move.from, move.to, err)
+		}
+	}
+
+	settings, err := loadSettings(filepath.Join(c.ConfigDir, "config.toml"))
+	if err != nil {
+		return err
+	}
+	c.Settings = settings
+
+	return errors.Join(collisions...)
+}
+
+func migrateDir(from, to string, keepLink bool) error {
+	if from == to {
+		return nil
+	}
+
+	// Only real directories are migrated; a symlink means we already did it
+	info, err := os.Lstat(from)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return os.Remove(from)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(to); os.IsNotExist(err) {
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		if keepLink {
+			return os.Symlink(to, from)
+		}
+		return nil
+	}
+
+	// Both exist: merge what does not collide. Entries moved while others
+	// stay behind get a link of their own, so the old paths still resolve.
+	var links, movable, collisions []string
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case migratedLink(from, to, name):
+			links = append(links, name)
+		case exists(filepath.Join(to, name)):
+			collisions = append(collisions, name)
+		default:
+			movable = append(movable, name)
+		}
+	}
+
+	linkEach := keepLink && len(collisions) > 0
+	var moved []string
+	for _, name := range movable {
+		err := os.Rename(filepath.Join(from, name), filepath.Join(to, name))
+		if err == nil && linkEach {
+			if err = os.Symlink(filepath.Join(to, name), filepath.Join(from, name)); err != nil {
+				os.Rename(filepath.Join(to, name), filepath.Join(from, name))
+			}
+		}
+		if err != nil {
+			// Put back what was moved so the old location stays whole
+			for _, name := range moved {
+				if linkEach {
+					os.Remove(filepath.Join(from, name))
+				}
+				os.Rename(filepath.Join(to, name), filepath.Join(from, name))
+			}
+			return err
+		}
+		moved = append(moved, name)
+	}
+
+	if len(collisions) > 0 {
+		return &CollisionError{From: from, To: to, Names: collisions}
+	}
+
+	// Everything is in the new location, replace the old one with a link
+	for _, name := range links {
+		if err := os.Remove(filepath.Join(from, name)); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(from); err != nil {
+		return err
+	}
+
+	if keepLink {
+		return os.Symlink(to, from)
+	}
+
+	return nil
+}
+
+// migratedLink reports whether name in from is the link an earlier partial
+// migration left to the entry moved to to
+func migratedLink(from, to, name string) bool {
+	dest, err := os.Readlink(filepath.Join(from, name))
+	return err == nil && dest == filepath.Join(to, name)
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
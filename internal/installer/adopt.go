@@ -0,0 +1,152 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// AdoptPath moves an existing path into the repository where Scan's
+// mappings would install it from, then links it back in place. It returns
+// the group the path belongs to and what was linked.
+func (i *Installer) AdoptPath(repoPath, path string) (DotfileGroup, LinkSet, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return DotfileGroup{}, LinkSet{}, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if dest, err := i.readlink(path); err == nil && i.isManaged(dest) {
+			return DotfileGroup{}, LinkSet{}, fmt.Errorf("%s is already managed by godots", path)
+		}
+	}
+
+	settings, err := LoadRepoSettings(repoPath)
+	if err != nil {
+		return DotfileGroup{}, LinkSet{}, err
+	}
+
+	group, err := i.groupFor(repoPath, settings, path)
+	if err != nil {
+		return DotfileGroup{}, LinkSet{}, err
+	}
+
+	if _, err := os.Lstat(group.Source); err == nil {
+		return DotfileGroup{}, LinkSet{}, fmt.Errorf("%s already exists in the repository", group.Source)
+	}
+
+	if err := i.Adopt(path, group.Source); err != nil {
+		return DotfileGroup{}, LinkSet{}, err
+	}
+
+	links, err := i.CreateSymlinks([]DotfileGroup{group})
+	if err != nil {
+		return group, links, err
+	}
+
+	return group, links, nil
+}
+
+// groupFor reverses the repository's mappings, returning a group whose
+// Source is where path belongs in the repository and whose Target is path
+func (i *Installer) groupFor(repoPath string, settings *RepoSettings, path string) (DotfileGroup, error) {
+	mappings, err := i.Mappings(settings)
+	if err != nil {
+		return DotfileGroup{}, err
+	}
+	if len(mappings) == 0 {
+		return DotfileGroup{}, fmt.Errorf("repository has no mappings")
+	}
+
+	var (
+		name   string
+		source string
+	)
+
+	// Groups with an explicit target take precedence over the mappings
+	for groupName, override := range settings.Groups {
+		if override.Target == "" {
+			continue
+		}
+
+		target := i.expandPath(override.Target)
+		if !isWithin(target, path) {
+			continue
+		}
+
+		rel, _ := filepath.Rel(target, path)
+		mapping := mappings[0]
+		for _, m := range mappings {
+			if _, err := os.Lstat(filepath.Join(repoPath, m.SourceDir, groupName)); err == nil {
+				mapping = m
+				break
+			}
+		}
+
+		name = groupName
+		source = filepath.Join(repoPath, mapping.SourceDir, groupName, rel)
+		break
+	}
+
+	// Otherwise use the mapping with the most specific target directory
+	if name == "" {
+		var best *PathMapping
+		for idx, m := range mappings {
+			rel, err := filepath.Rel(m.TargetDir, path)
+			if err != nil || rel == "." || !isWithin(m.TargetDir, path) {
+				continue
+			}
+			if slices.Contains(m.Skip, strings.SplitN(rel, string(filepath.Separator), 2)[0]) {
+				continue
+			}
+			if best == nil || len(m.TargetDir) > len(best.TargetDir) {
+				best = &mappings[idx]
+			}
+		}
+
+		if best == nil {
+			return DotfileGroup{}, fmt.Errorf("%s is not below any mapped target directory", path)
+		}
+
+		rel, _ := filepath.Rel(best.TargetDir, path)
+		name = strings.SplitN(rel, string(filepath.Separator), 2)[0]
+		source = filepath.Join(repoPath, best.SourceDir, rel)
+	}
+
+	strategy, err := settings.GroupStrategy(name, i.strategy)
+	if err != nil {
+		return DotfileGroup{}, err
+	}
+
+	return DotfileGroup{
+		Name:     name,
+		Source:   source,
+		Target:   path,
+		Files:    []string{filepath.Base(source)},
+		Strategy: strategy,
+	}, nil
+}
+
+// CommitAdopted stages the adopted sources in the cached repository and commits them
+func (i *Installer) CommitAdopted(repoPath string, sources []string, message string) error {
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err != nil {
+		return fmt.Errorf("%s is not a git repository", repoPath)
+	}
+
+	add := exec.Command("git", append([]string{"-C", repoPath, "add", "--"}, sources...)...)
+	add.Stderr = os.Stderr
+	if err := add.Run(); err != nil {
+		return fmt.Errorf("git add failed: %w", err)
+	}
+
+	commit := exec.Command("git", "-C", repoPath, "commit", "-m", message)
+	commit.Stdout = os.Stdout
+	commit.Stderr = os.Stderr
+	if err := commit.Run(); err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+
+	return nil
+}
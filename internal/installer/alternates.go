@@ -0,0 +1,101 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// alternateSeparator splits a file name from its conditions, e.g. "hypr##host.laptop"
+const alternateSeparator = "##"
+
+// sourceEntry is a directory entry after ignore rules and alternates are applied
+type sourceEntry struct {
+	Name     string // Name at the target, without alternate or template suffixes
+	Path     string // Path of the selected source
+	Variant  string // Selected alternate file name, empty for plain entries
+	IsDir    bool
+	Template bool // Source is a .tmpl file that must be rendered
+}
+
+// readSourceDir lists dir, dropping ignored entries and picking the
+// best-matching alternate for every name
+func (i *Installer) readSourceDir(dir string, ignore *Ignore) ([]sourceEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	facts := i.Facts()
+
+	var result []sourceEntry
+	best := make(map[string]int) // name -> index into result
+	scores := make(map[string]int)
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if ignore.Match(path, entry.IsDir()) {
+			continue
+		}
+
+		name, conditions, isAlternate := strings.Cut(entry.Name(), alternateSeparator)
+
+		isTemplate := false
+		if !entry.IsDir() {
+			name, isTemplate = strings.CutSuffix(name, TemplateSuffix)
+		}
+		score := 0
+		if isAlternate {
+			var ok bool
+			if score, ok = matchConditions(facts, conditions); !ok || name == "" {
+				continue
+			}
+		}
+
+		candidate := sourceEntry{
+			Name:     name,
+			Path:     path,
+			IsDir:    entry.IsDir(),
+			Template: isTemplate,
+		}
+		if isAlternate {
+			candidate.Variant = entry.Name()
+		}
+
+		idx, seen := best[name]
+		if !seen {
+			best[name] = len(result)
+			scores[name] = score
+			result = append(result, candidate)
+			continue
+		}
+
+		if score > scores[name] {
+			scores[name] = score
+			result[idx] = candidate
+		}
+	}
+
+	return result, nil
+}
+
+// matchConditions checks a comma-separated condition list against the facts.
+// It returns the number of matched conditions so more specific alternates win;
+// "default" matches with no weight.
+func matchConditions(facts Facts, conditions string) (int, bool) {
+	score := 0
+
+	for _, condition := range strings.Split(conditions, ",") {
+		if condition == "default" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(condition, ".")
+		if !ok || !facts.Matches(key, value) {
+			return 0, false
+		}
+		score++
+	}
+
+	return score, true
+}
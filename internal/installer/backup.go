@@ -2,64 +2,450 @@ package installer
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
-func (i *Installer) CheckConflicts(groups []DotfileGroup) ([]string, error) {
-	var conflicts []string
+// BackupIndexFile describes the contents of a backup directory
+const BackupIndexFile = "index.toml"
+
+// backupFilesDir holds the backed up paths, apart from the index
+const backupFilesDir = "files"
+
+// BackupIndex records what a backup holds and where it came from
+type BackupIndex struct {
+	ID        string        `toml:"id"`
+	Repo      string        `toml:"repo"`
+	Operation string        `toml:"operation"`
+	Store     string        `toml:"store"`
+	CreatedAt time.Time     `toml:"created_at"`
+	Entries   []BackupEntry `toml:"entries"`
+}
+
+// BackupEntry is one path moved into a backup
+type BackupEntry struct {
+	Original string      `toml:"original"`
+	Path     string      `toml:"path"` // Relative to the backup directory, unused by the blob store
+	Type     string      `toml:"type"` // file, dir or symlink
+	Mode     os.FileMode `toml:"mode"`
 
-	for _, group := range groups {
-		if _, err := os.Lstat(group.Target); err == nil {
-			conflicts = append(conflicts, group.Target)
+	// SHA-256 of each regular file, keyed by path relative to the entry
+	Checksums map[string]string `toml:"checksums"`
+
+	// Everything below the path, for backups in the blob store
+	Files []BlobFile `toml:"files,omitempty"`
+}
+
+// Backup moves paths into a new timestamped backup directory and writes its index
+func (i *Installer) Backup(repo, operation string, paths []string) (string, error) {
+	if i.plan != nil {
+		for _, path := range paths {
+			i.act(ActionBackup, path, "")
 		}
+		return "", nil
 	}
 
-	return conflicts, nil
-}
+	store, err := i.backupStore()
+	if err != nil {
+		return "", err
+	}
 
-func (i *Installer) Backup(paths []string) (string, error) {
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
 	backupDir := filepath.Join(i.cfg.BackupDir, timestamp)
 
+	// Keep backups taken within the same second apart
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(backupDir); os.IsNotExist(err) {
+			break
+		}
+		backupDir = filepath.Join(i.cfg.BackupDir, fmt.Sprintf("%s-%d", timestamp, n))
+	}
+
 	if err := os.MkdirAll(backupDir, 0755); err != nil {
 		return "", err
 	}
 
+	index := BackupIndex{
+		ID:        filepath.Base(backupDir),
+		Repo:      repo,
+		Operation: operation,
+		Store:     store,
+		CreatedAt: time.Now(),
+	}
+
 	for _, path := range paths {
-		// Get relative path from home
-		relPath, err := filepath.Rel(i.cfg.HomeDir, path)
+		info, err := os.Lstat(path)
 		if err != nil {
 			return "", err
 		}
 
-		backupPath := filepath.Join(backupDir, relPath)
-		backupParent := filepath.Dir(backupPath)
+		entry := BackupEntry{
+			Original: path,
+			Type:     fileType(info),
+			Mode:     info.Mode(),
+		}
 
-		// Create parent directories
-		if err := os.MkdirAll(backupParent, 0755); err != nil {
+		if store == StoreBlobs {
+			if entry.Files, entry.Checksums, err = i.storeBlobs(path); err != nil {
+				return "", fmt.Errorf("failed to backup %s: %w", path, err)
+			}
+		} else {
+			entry.Path = i.backupRelPath(path)
+			if entry.Checksums, err = checksumTree(path); err != nil {
+				return "", fmt.Errorf("failed to checksum %s: %w", path, err)
+			}
+		}
+
+		// Index and journal the entry before the original goes, so whatever
+		// happens from here on can be restored and rolled back
+		index.Entries = append(index.Entries, entry)
+		if err := i.writeBackupIndex(backupDir, index); err != nil {
+			return "", err
+		}
+		if err := i.record(Step{Kind: StepBackup, Path: path, Backup: index.ID}); err != nil {
 			return "", err
 		}
 
-		// Move file to backup
-		if err := os.Rename(path, backupPath); err != nil {
-			// If rename fails, try copy
-			if err := copyFile(path, backupPath); err != nil {
+		if store == StoreBlobs {
+			if err := os.RemoveAll(path); err != nil {
 				return "", fmt.Errorf("failed to backup %s: %w", path, err)
 			}
-			os.Remove(path)
+			continue
+		}
+
+		backupPath := filepath.Join(backupDir, entry.Path)
+		if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+			return "", err
+		}
+		if err := movePath(path, backupPath); err != nil {
+			return "", fmt.Errorf("failed to backup %s: %w", path, err)
 		}
 	}
 
 	return backupDir, nil
 }
 
-func copyFile(src, dst string) error {
-	data, err := os.ReadFile(src)
+// undoBackup puts back a path an operation being rolled back moved into a
+// backup. An interrupted backup may not have moved it at all, or only part
+// of it; the index entry was written first and tells which.
+func (i *Installer) undoBackup(id, path string) error {
+	index, err := i.LoadBackup(id)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(dst, data, 0644)
+	n := slices.IndexFunc(index.Entries, func(entry BackupEntry) bool { return entry.Original == path })
+	if n < 0 {
+		return nil // Restored already
+	}
+	entry := index.Entries[n]
+	backupDir := filepath.Join(i.cfg.BackupDir, id)
+
+	if _, err := os.Lstat(path); err == nil {
+		// The original never left, drop the entry and anything half copied
+		if problems, err := verifyTree(path, entry.Checksums); err == nil && len(problems) == 0 {
+			if entry.Path != "" {
+				if err := os.RemoveAll(filepath.Join(backupDir, entry.Path)); err != nil {
+					return err
+				}
+			}
+
+			index.Entries = slices.Delete(index.Entries, n, n+1)
+			if len(index.Entries) == 0 {
+				return os.RemoveAll(backupDir)
+			}
+			return i.writeBackupIndex(backupDir, index)
+		}
+
+		// What is left of a move that was cut short, the backup has it all
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	_, err = i.Restore(id, []string{path}, nil)
+	return err
+}
+
+// backupRelPath places paths below files/ by their home-relative path, and
+// anything outside home under files/_root by its absolute path. Backups
+// taken before files/ existed keep the paths recorded in their index.
+func (i *Installer) backupRelPath(path string) string {
+	if isWithin(i.cfg.HomeDir, path) {
+		rel, err := filepath.Rel(i.cfg.HomeDir, path)
+		if err == nil {
+			return filepath.Join(backupFilesDir, rel)
+		}
+	}
+
+	return filepath.Join(backupFilesDir, "_root", i.cfg.Unroot(path))
+}
+
+// Backups lists every backup that has an index, oldest first
+func (i *Installer) Backups() ([]BackupIndex, error) {
+	backups, _, err := i.scanBackups()
+	return backups, err
+}
+
+// scanBackups lists every backup that has an index, oldest first, along with
+// the IDs of backups whose index exists but cannot be read. Backups that
+// predate indexes are left out of both.
+func (i *Installer) scanBackups() ([]BackupIndex, []string, error) {
+	entries, err := os.ReadDir(i.cfg.BackupDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		backups []BackupIndex
+		damaged []string
+	)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if _, err := os.Lstat(filepath.Join(i.cfg.BackupDir, entry.Name(), BackupIndexFile)); os.IsNotExist(err) {
+			continue
+		}
+
+		index, err := i.LoadBackup(entry.Name())
+		if err != nil {
+			damaged = append(damaged, entry.Name())
+			continue
+		}
+		backups = append(backups, index)
+	}
+
+	sort.Slice(backups, func(a, b int) bool {
+		return backups[a].CreatedAt.Before(backups[b].CreatedAt)
+	})
+
+	return backups, damaged, nil
+}
+
+// LoadBackup reads the index of a backup
+func (i *Installer) LoadBackup(id string) (BackupIndex, error) {
+	var index BackupIndex
+
+	if id == "" || id != filepath.Base(id) {
+		return index, fmt.Errorf("invalid backup id %q", id)
+	}
+
+	if _, err := toml.DecodeFile(filepath.Join(i.cfg.BackupDir, id, BackupIndexFile), &index); err != nil {
+		if os.IsNotExist(err) {
+			return index, fmt.Errorf("backup %s not found", id)
+		}
+		return index, err
+	}
+
+	return index.mapPaths(i.cfg.Reroot), nil
+}
+
+// Restore moves backed up paths back to their original location. With no
+// paths, everything in the backup is restored; otherwise only entries at or
+// below the given paths. Godots links in the way are removed, as are copies
+// recorded in copies (file -> SHA-256 written) that are still unchanged;
+// anything else in the way is left alone and reported. It returns the
+// restored paths.
+func (i *Installer) Restore(id string, paths []string, copies map[string]string) ([]string, error) {
+	index, err := i.LoadBackup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	backupDir := filepath.Join(i.cfg.BackupDir, id)
+
+	var (
+		restored  []string
+		remaining []BackupEntry
+		failures  []error
+	)
+
+	for _, entry := range index.Entries {
+		if !matchesAny(entry.Original, paths) {
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		if err := i.restoreEntry(backupDir, index.Store, entry, copies); err != nil {
+			failures = append(failures, err)
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		restored = append(restored, entry.Original)
+
+		// The backup copy is gone now, so a mismatch can only be reported
+		problems, err := verifyTree(entry.Original, entry.Checksums)
+		if err != nil {
+			failures = append(failures, err)
+		} else if len(problems) > 0 {
+			failures = append(failures, fmt.Errorf("%s does not match its backup: %v", entry.Original, problems))
+		}
+	}
+
+	// Drop restored entries from the index, and the backup once it is empty
+	index.Entries = remaining
+	if len(remaining) == 0 {
+		if err := os.RemoveAll(backupDir); err != nil {
+			return restored, err
+		}
+	} else if err := i.writeBackupIndex(backupDir, index); err != nil {
+		return restored, err
+	}
+
+	if index.Store == StoreBlobs {
+		if _, err := i.collectBlobs(nil, false); err != nil {
+			return restored, err
+		}
+	}
+
+	if len(failures) > 0 {
+		return restored, fmt.Errorf("restore incomplete: %v", failures)
+	}
+
+	return restored, nil
+}
+
+func (i *Installer) restoreEntry(backupDir, store string, entry BackupEntry, copies map[string]string) error {
+	// Clear a godots link or unchanged copy occupying the original location
+	if info, err := os.Lstat(entry.Original); err == nil {
+		if info.Mode()&os.ModeSymlink != 0 {
+			dest, err := i.readlink(entry.Original)
+			if err != nil || !i.isManaged(dest) {
+				return fmt.Errorf("%s exists and is not managed by godots", entry.Original)
+			}
+		} else if err := unchangedCopies(entry.Original, copies); err != nil {
+			return fmt.Errorf("%s exists and is not managed by godots: %w", entry.Original, err)
+		}
+		if err := os.RemoveAll(entry.Original); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(entry.Original), 0755); err != nil {
+		return err
+	}
+
+	if store == StoreBlobs {
+		if err := i.restoreBlobs(entry); err != nil {
+			os.RemoveAll(entry.Original)
+			return fmt.Errorf("failed to restore %s: %w", entry.Original, err)
+		}
+		return nil
+	}
+
+	backupPath := filepath.Join(backupDir, entry.Path)
+	if err := movePath(backupPath, entry.Original); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", entry.Original, err)
+	}
+
+	return nil
+}
+
+// VerifyBackup checks the files in a backup against the checksums recorded
+// when it was taken and describes every file that is missing or changed
+func (i *Installer) VerifyBackup(id string) ([]string, error) {
+	index, err := i.LoadBackup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	backupDir := filepath.Join(i.cfg.BackupDir, id)
+
+	var problems []string
+	for _, entry := range index.Entries {
+		if index.Store == StoreBlobs {
+			problems = append(problems, i.verifyBlobs(entry)...)
+			continue
+		}
+
+		found, err := verifyTree(filepath.Join(backupDir, entry.Path), entry.Checksums)
+		if err != nil {
+			return problems, err
+		}
+		problems = append(problems, found...)
+	}
+
+	return problems, nil
+}
+
+// writeBackupIndex stores the original paths as seen from inside the
+// configured root, like the manifest
+func (i *Installer) writeBackupIndex(backupDir string, index BackupIndex) error {
+	f, err := os.Create(filepath.Join(backupDir, BackupIndexFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return toml.NewEncoder(f).Encode(index.mapPaths(i.cfg.Unroot))
+}
+
+// mapPaths returns a copy of the index with the original paths passed through fn
+func (b BackupIndex) mapPaths(fn func(string) string) BackupIndex {
+	entries := make([]BackupEntry, len(b.Entries))
+	for n, entry := range b.Entries {
+		entry.Original = fn(entry.Original)
+		entries[n] = entry
+	}
+	b.Entries = entries
+	return b
+}
+
+// unchangedCopies checks that path holds nothing but files godots copied
+// there, each still matching the hash recorded in copies
+func unchangedCopies(path string, copies map[string]string) error {
+	found := 0
+	err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		found++
+
+		want, ok := copies[file]
+		if !ok || !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not a copy", file)
+		}
+		if got, err := hashFile(file); err != nil || got != want {
+			return fmt.Errorf("%s was changed since it was copied", file)
+		}
+		return nil
+	})
+	if err == nil && found == 0 {
+		return fmt.Errorf("%s holds no copies", path)
+	}
+	return err
+}
+
+// matchesAny reports whether path is at or below one of roots; no roots matches everything
+func matchesAny(path string, roots []string) bool {
+	if len(roots) == 0 {
+		return true
+	}
+
+	for _, root := range roots {
+		if isWithin(root, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func fileType(info os.FileInfo) string {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return "symlink"
+	case info.IsDir():
+		return "dir"
+	}
+	return "file"
 }
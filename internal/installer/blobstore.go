@@ -0,0 +1,310 @@
+package installer
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backup stores
+const (
+	StoreDir   = "dir"   // Backed up paths are moved into the backup directory
+	StoreBlobs = "blobs" // File contents are deduplicated and compressed in the blob directory
+)
+
+// BlobFile describes one entry of a path kept in the blob store. The
+// contents of regular files are found through the entry's checksums.
+type BlobFile struct {
+	Path    string      `toml:"path"` // Relative to the backed up path, "." for itself
+	Type    string      `toml:"type"`
+	Mode    os.FileMode `toml:"mode"`
+	ModTime time.Time   `toml:"mod_time"`
+	Target  string      `toml:"target,omitempty"` // Symlink destination
+	UID     int         `toml:"uid"`
+	GID     int         `toml:"gid"`
+}
+
+// backupStore returns the configured backup store
+func (i *Installer) backupStore() (string, error) {
+	switch store := i.cfg.Settings.Backups.Store; store {
+	case "", StoreDir:
+		return StoreDir, nil
+	case StoreBlobs:
+		return StoreBlobs, nil
+	default:
+		return "", fmt.Errorf("unknown backup store %q (expected %s or %s)", store, StoreDir, StoreBlobs)
+	}
+}
+
+// storeBlobs records path and everything below it, adding file contents to
+// the blob store. The path itself is left in place.
+func (i *Installer) storeBlobs(path string) ([]BlobFile, map[string]string, error) {
+	var files []BlobFile
+	sums := make(map[string]string)
+
+	err := filepath.WalkDir(path, func(current string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(path, current)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		file := BlobFile{
+			Path:    rel,
+			Type:    fileType(info),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}
+		file.UID, file.GID, _ = fileOwner(info)
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if file.Target, err = os.Readlink(current); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if sums[rel], err = i.putBlob(current); err != nil {
+				return err
+			}
+		case !info.IsDir():
+			return fmt.Errorf("cannot back up %s: unsupported file type %s", current, info.Mode().Type())
+		}
+
+		files = append(files, file)
+		return nil
+	})
+
+	return files, sums, err
+}
+
+// putBlob adds a file's contents to the store unless they are already there
+// and returns their hash
+func (i *Installer) putBlob(path string) (string, error) {
+	hash, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	blob := i.blobPath(hash)
+	if _, err := os.Stat(blob); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(blob), 0700); err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	// Write under a temporary name so a blob is never seen half written
+	tmp, err := os.CreateTemp(filepath.Dir(blob), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := gzip.NewWriter(tmp)
+	if _, err := io.Copy(zw, src); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), blob); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+func (i *Installer) blobPath(hash string) string {
+	return filepath.Join(i.cfg.BlobDir, hash[:2], hash)
+}
+
+// openBlob returns a reader for the uncompressed contents of a blob
+func (i *Installer) openBlob(hash string) (io.ReadCloser, error) {
+	f, err := os.Open(i.blobPath(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, f}, nil
+}
+
+// restoreBlobs recreates a backed up path from the blob store
+func (i *Installer) restoreBlobs(entry BackupEntry) error {
+	for _, file := range entry.Files {
+		dst := filepath.Join(entry.Original, filepath.FromSlash(file.Path))
+
+		var err error
+		switch file.Type {
+		case "dir":
+			// Writable until its children are in place
+			err = os.Mkdir(dst, 0700)
+		case "symlink":
+			err = os.Symlink(file.Target, dst)
+		default:
+			err = i.writeBlob(entry.Checksums[file.Path], dst)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Apply metadata deepest first so directory times survive their children
+	for n := len(entry.Files) - 1; n >= 0; n-- {
+		file := entry.Files[n]
+		dst := filepath.Join(entry.Original, filepath.FromSlash(file.Path))
+
+		if err := lchownIDs(dst, file.UID, file.GID); err != nil {
+			return err
+		}
+		if file.Type == "symlink" {
+			continue
+		}
+		if err := os.Chmod(dst, file.Mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dst, time.Time{}, file.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *Installer) writeBlob(hash, dst string) error {
+	if hash == "" {
+		return fmt.Errorf("no checksum recorded for %s", dst)
+	}
+
+	src, err := i.openBlob(hash)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// verifyBlobs checks that every blob an entry needs exists and is intact
+func (i *Installer) verifyBlobs(entry BackupEntry) []string {
+	var problems []string
+
+	for rel, want := range entry.Checksums {
+		name := filepath.Join(entry.Original, filepath.FromSlash(rel))
+
+		src, err := i.openBlob(want)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+
+		hash := sha256.New()
+		_, err = io.Copy(hash, src)
+		src.Close()
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		} else if hex.EncodeToString(hash.Sum(nil)) != want {
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch", name))
+		}
+	}
+
+	return problems
+}
+
+// collectBlobs removes blobs no backup refers to, ignoring the backups in
+// exclude, and returns the bytes freed. With dryRun nothing is removed.
+// Nothing is collected while the index of any backup cannot be read, as
+// the blobs it refers to are unknown.
+func (i *Installer) collectBlobs(exclude map[string]bool, dryRun bool) (int64, error) {
+	if _, err := os.Stat(i.cfg.BlobDir); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	backups, damaged, err := i.scanBackups()
+	if err != nil {
+		return 0, err
+	}
+	if len(damaged) > 0 {
+		return 0, fmt.Errorf("not collecting unused blobs, the index of backup %s cannot be read", strings.Join(damaged, ", "))
+	}
+
+	referenced := make(map[string]bool)
+	for _, backup := range backups {
+		if exclude[backup.ID] || backup.Store != StoreBlobs {
+			continue
+		}
+		for _, entry := range backup.Entries {
+			for _, hash := range entry.Checksums {
+				referenced[hash] = true
+			}
+		}
+	}
+
+	var freed int64
+	err = filepath.WalkDir(i.cfg.BlobDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || referenced[d.Name()] {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		freed += info.Size()
+
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+
+	return freed, err
+}
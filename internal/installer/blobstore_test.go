@@ -0,0 +1,134 @@
+package installer
+
+import (
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// countBlobs returns how many blobs the store holds
+func countBlobs(t *testing.T, i *Installer) int {
+	t.Helper()
+
+	count := 0
+	err := filepath.WalkDir(i.cfg.BlobDir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err == nil && !d.IsDir() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	tests := []struct {
+		store string
+		blobs []int // Blobs held after the first backup, the second and each restore
+	}{
+		{StoreDir, []int{0, 0, 0, 0}},
+		{StoreBlobs, []int{2, 3, 2, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.store, func(t *testing.T) {
+			i, home := newTestInstaller(t)
+			i.cfg.Settings.Backups.Store = tt.store
+
+			// Two files share their contents, so they share a blob
+			nvim := filepath.Join(home, ".config", "nvim")
+			files := map[string]string{
+				filepath.Join(nvim, "init.lua"):           "vim.o.number = true\n",
+				filepath.Join(nvim, "lua", "plugins.lua"): "return {}\n",
+				filepath.Join(home, ".zshrc"):             "return {}\n",
+			}
+			for path, content := range files {
+				writeFile(t, path, content)
+			}
+			if err := os.Chmod(filepath.Join(nvim, "init.lua"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("init.lua", filepath.Join(nvim, "link.lua")); err != nil {
+				t.Fatal(err)
+			}
+
+			first, err := i.Backup("dots", "install", []string{nvim, filepath.Join(home, ".zshrc")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := countBlobs(t, i); got != tt.blobs[0] {
+				t.Errorf("first backup stored %d blobs, want %d", got, tt.blobs[0])
+			}
+
+			// Backing up the same contents again only adds what is new
+			writeFile(t, filepath.Join(home, ".zshrc"), "return {}\n")
+			writeFile(t, filepath.Join(home, ".bashrc"), "export EDITOR=nvim\n")
+			second, err := i.Backup("dots", "update", []string{filepath.Join(home, ".zshrc"), filepath.Join(home, ".bashrc")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := countBlobs(t, i); got != tt.blobs[1] {
+				t.Errorf("second backup left %d blobs, want %d", got, tt.blobs[1])
+			}
+
+			for _, path := range append(slices.Collect(maps.Keys(files)), filepath.Join(home, ".bashrc")) {
+				if _, err := os.Lstat(path); !os.IsNotExist(err) {
+					t.Errorf("%s was not moved into a backup", path)
+				}
+			}
+
+			for n, dir := range []string{first, second} {
+				id := filepath.Base(dir)
+				if problems, err := i.VerifyBackup(id); err != nil || len(problems) > 0 {
+					t.Fatalf("VerifyBackup(%s) = %v, %v", id, problems, err)
+				}
+
+				restored, err := i.Restore(id, nil, nil)
+				if err != nil {
+					t.Fatalf("Restore(%s) = %v", id, err)
+				}
+				if len(restored) != 2 {
+					t.Errorf("Restore(%s) restored %v, want 2 paths", id, restored)
+				}
+				if got := countBlobs(t, i); got != tt.blobs[n+2] {
+					t.Errorf("restoring %s left %d blobs, want %d", id, got, tt.blobs[n+2])
+				}
+				if _, err := os.Stat(dir); !os.IsNotExist(err) {
+					t.Errorf("backup %s still exists after restoring everything", id)
+				}
+
+				// Both backups hold a .zshrc, make way for the second one
+				if n == 0 {
+					if err := os.Remove(filepath.Join(home, ".zshrc")); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			for path, content := range files {
+				if got := readFile(t, path); got != content {
+					t.Errorf("%s = %q, want %q", path, got, content)
+				}
+			}
+			if got := readFile(t, filepath.Join(home, ".bashrc")); got != "export EDITOR=nvim\n" {
+				t.Errorf(".bashrc = %q after restore", got)
+			}
+			if info, err := os.Stat(filepath.Join(nvim, "init.lua")); err != nil {
+				t.Error(err)
+			} else if info.Mode().Perm() != 0600 {
+				t.Errorf("init.lua mode = %v, want 0600", info.Mode().Perm())
+			}
+			if target, err := os.Readlink(filepath.Join(nvim, "link.lua")); err != nil || target != "init.lua" {
+				t.Errorf("link.lua points to %q, %v, want init.lua", target, err)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -17,18 +18,29 @@ const (
 	SourceTypeLocalDir SourceType = "local-dir" // Local directory (non-git)
 )
 
-// Clone handles cloning/copying a repository from various sources
-func (i *Installer) Clone(source string) (string, string, SourceType, error) {
-	sourceType := detectSourceType(source)
-	repoName := extractRepoName(source)
-	repoPath := filepath.Join(i.cfg.CacheDir, repoName)
+// SourceFile records where a cached copy of a local source came from. It is
+// kept inside .git for git repositories so it never shows up as a change.
+const SourceFile = ".godots-source"
+
+// Clone handles cloning/copying a repository from various sources. The cache
+// is named name, or after the source when name is empty.
+func (i *Installer) Clone(source, name string) (string, string, SourceType, error) {
+	repoPath, repoName, sourceType := i.locate(source, name)
 
 	// Check if already exists in cache
 	if _, err := os.Stat(repoPath); err == nil {
-		// Already exists, just return path
+		// Only reuse it for the source it was made from
+		if err := checkOrigin(repoPath, source); err != nil {
+			return "", "", "", err
+		}
 		return repoPath, repoName, sourceType, nil
 	}
 
+	// Recorded first, so a rollback also removes a clone cut short
+	if err := i.record(Step{Kind: StepClone, Path: repoPath}); err != nil {
+		return "", "", "", err
+	}
+
 	switch sourceType {
 	case SourceTypeRemote:
 		// Clone remote git repository
@@ -42,14 +54,237 @@ func (i *Installer) Clone(source string) (string, string, SourceType, error) {
 
 	case SourceTypeLocalGit, SourceTypeLocalDir:
 		// Copy local directory to cache
-		if err := copyDir(source, repoPath); err != nil {
+		if err := copyDir(source, repoPath, copyIgnore()); err != nil {
 			return "", "", "", fmt.Errorf("failed to copy local directory: %w", err)
 		}
+		if err := os.WriteFile(sourceFile(repoPath), []byte(CanonicalSource(source)+"\n"), 0644); err != nil {
+			return "", "", "", fmt.Errorf("failed to record source: %w", err)
+		}
 	}
 
 	return repoPath, repoName, sourceType, nil
 }
 
+// locate returns where a source is cached, its name and its type
+func (i *Installer) locate(source, name string) (string, string, SourceType) {
+	if name == "" {
+		name = RepoNames(source)[0]
+	}
+	return filepath.Join(i.cfg.CacheDir, name), name, detectSourceType(source)
+}
+
+// CacheMatches reports whether the repository named name is cached and, if
+// so, whether the cache was made from source
+func (i *Installer) CacheMatches(name, source string) (bool, bool) {
+	repoPath := filepath.Join(i.cfg.CacheDir, name)
+	if _, err := os.Stat(repoPath); err != nil {
+		return false, false
+	}
+	return true, checkOrigin(repoPath, source) == nil
+}
+
+// sourceFile returns where the source of a cached copy is recorded
+func sourceFile(repoPath string) string {
+	if info, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil && info.IsDir() {
+		return filepath.Join(repoPath, ".git", SourceFile)
+	}
+	return filepath.Join(repoPath, SourceFile)
+}
+
+// checkOrigin fails when the repository cached at repoPath was made from
+// another source than source
+func checkOrigin(repoPath, source string) error {
+	origin := ""
+	if data, err := os.ReadFile(sourceFile(repoPath)); err == nil {
+		origin = strings.TrimSpace(string(data))
+	} else if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil && isURL(source) {
+		// Clones know their URL; copies made before sources were recorded
+		// only know the remote of the original, so those pass
+		origin, _ = gitOutput(repoPath, "remote", "get-url", "origin")
+	}
+
+	if origin == "" || SameSource(origin, source) {
+		return nil
+	}
+	return fmt.Errorf("%s holds %s, not %s", repoPath, origin, source)
+}
+
+// CanonicalSource returns source as it is recorded: local paths are made
+// absolute, URLs are kept as they are
+func CanonicalSource(source string) string {
+	if isURL(source) {
+		return source
+	}
+	if abs, err := filepath.Abs(source); err == nil {
+		return abs
+	}
+	return source
+}
+
+// SameSource reports whether two sources name the same repository,
+// ignoring a trailing slash or .git
+func SameSource(a, b string) bool {
+	normalize := func(source string) string {
+		source = strings.TrimSuffix(CanonicalSource(source), "/")
+		return strings.TrimSuffix(source, ".git")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// CheckName validates a repository name given by the user
+func CheckName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid repository name %q", name)
+	}
+	return nil
+}
+
+// Preview is a view of a repository's contents for planning, made without
+// changing the cache
+type Preview struct {
+	RepoPath   string // Where the repository is or will be cached
+	RepoName   string
+	SourceType SourceType
+	Fetch      string // What preparing the repository does to the cache
+	Contents   string // Directory holding the contents to plan against
+
+	tmpDir string
+}
+
+// Cleanup removes the temporary checkout a preview may have made
+func (p Preview) Cleanup() {
+	if p.tmpDir != "" {
+		os.RemoveAll(p.tmpDir)
+	}
+}
+
+// PreviewClone shows what Clone would prepare. Cached repositories are used
+// as they are, remote ones are cloned to a temporary directory and local
+// ones are read in place. Plans made afterwards treat the contents as if
+// they were cached.
+func (i *Installer) PreviewClone(source, name string) (Preview, error) {
+	repoPath, repoName, sourceType := i.locate(source, name)
+	preview := Preview{RepoPath: repoPath, RepoName: repoName, SourceType: sourceType}
+
+	if _, err := os.Stat(repoPath); err == nil {
+		if err := checkOrigin(repoPath, source); err != nil {
+			return preview, err
+		}
+		preview.Fetch = fmt.Sprintf("reuse cached %s", repoPath)
+		preview.Contents = repoPath
+		return preview, nil
+	}
+
+	defer func() { i.preview = &preview }()
+
+	switch sourceType {
+	case SourceTypeRemote:
+		tmp, err := os.MkdirTemp("", "godots-plan-")
+		if err != nil {
+			return preview, err
+		}
+		preview.tmpDir = tmp
+		preview.Contents = filepath.Join(tmp, repoName)
+
+		cmd := exec.Command("git", "clone", "--quiet", "--depth", "1", source, preview.Contents)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			os.RemoveAll(tmp)
+			return preview, fmt.Errorf("git clone failed: %w", err)
+		}
+		preview.Fetch = fmt.Sprintf("clone %s into %s", source, repoPath)
+
+	default:
+		abs, err := filepath.Abs(source)
+		if err != nil {
+			return preview, err
+		}
+		preview.Contents = abs
+		preview.Fetch = fmt.Sprintf("copy %s into %s", abs, repoPath)
+	}
+
+	return preview, nil
+}
+
+// PreviewUpdate shows what Update would pull. Git repositories are cloned
+// from the cache to a temporary directory and pulled there, and plans made
+// afterwards use that checkout in place of the cache.
+func (i *Installer) PreviewUpdate(repoPath string, sourceType SourceType) (Preview, error) {
+	preview := Preview{
+		RepoPath:   repoPath,
+		RepoName:   filepath.Base(repoPath),
+		SourceType: sourceType,
+		Fetch:      "none",
+		Contents:   repoPath,
+	}
+
+	if sourceType == SourceTypeLocalDir {
+		return preview, nil
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err != nil {
+		return preview, nil
+	}
+
+	// Pull from the same place the cache would
+	upstream, err := gitOutput(repoPath, "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{u}")
+	if err != nil {
+		return preview, nil // No upstream, so nothing to pull
+	}
+	remote, branch, _ := strings.Cut(upstream, "/")
+	url, err := gitOutput(repoPath, "remote", "get-url", remote)
+	if err != nil {
+		return preview, nil
+	}
+
+	tmp, err := os.MkdirTemp("", "godots-plan-")
+	if err != nil {
+		return preview, err
+	}
+	contents := filepath.Join(tmp, preview.RepoName)
+
+	for _, args := range [][]string{
+		{"clone", "--quiet", repoPath, contents},
+		{"-C", contents, "pull", "--quiet", "--ff-only", url, branch},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			os.RemoveAll(tmp)
+			return preview, fmt.Errorf("failed to preview pull: %w", err)
+		}
+	}
+
+	preview.Contents = contents
+	preview.tmpDir = tmp
+	i.preview = &preview
+	preview.Fetch = fmt.Sprintf("pull %s from %s", branch, url)
+	return preview, nil
+}
+
+// cachePath maps a path in previewed contents to where it will be cached
+func (i *Installer) cachePath(path string) string {
+	if i.preview == nil || !isWithin(i.preview.Contents, path) {
+		return path
+	}
+
+	rel, err := filepath.Rel(i.preview.Contents, path)
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(i.preview.RepoPath, rel)
+}
+
+// gitOutput runs a git command in dir and returns its trimmed output
+func gitOutput(dir string, args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
 // Update handles updating a repository based on its source type
 func (i *Installer) Update(repoPath string, sourceType SourceType) error {
 	switch sourceType {
@@ -57,6 +292,19 @@ func (i *Installer) Update(repoPath string, sourceType SourceType) error {
 		// Check if it's a git repository
 		gitDir := filepath.Join(repoPath, ".git")
 		if _, err := os.Stat(gitDir); err == nil {
+			// A cache pinned to a commit, as apply leaves it without a
+			// branch, has nothing to pull
+			if _, err := gitOutput(repoPath, "symbolic-ref", "--quiet", "HEAD"); err != nil {
+				return fmt.Errorf("%s is pinned to commit %s (detached HEAD), check out a branch in it to update", repoPath, Revision{Commit: HeadCommit(repoPath)}.Short())
+			}
+
+			// A rollback puts the cache back on the commit the links point into
+			if head := HeadCommit(repoPath); head != "" {
+				if err := i.record(Step{Kind: StepPull, Path: repoPath, Commit: head}); err != nil {
+					return err
+				}
+			}
+
 			// Git pull for remote repos or local git repos with remotes
 			cmd := exec.Command("git", "-C", repoPath, "pull")
 			cmd.Stdout = os.Stdout
@@ -82,7 +330,7 @@ func (i *Installer) Update(repoPath string, sourceType SourceType) error {
 // detectSourceType determines if the source is a remote URL, local git repo, or local directory
 func detectSourceType(source string) SourceType {
 	// Check if it's a URL (http://, https://, git@, etc.)
-	if strings.Contains(source, "://") || strings.HasPrefix(source, "git@") {
+	if isURL(source) {
 		return SourceTypeRemote
 	}
 
@@ -109,37 +357,57 @@ func detectSourceType(source string) SourceType {
 	return SourceTypeLocalDir
 }
 
-func extractRepoName(source string) string {
-	// Extract repo name from source
-	// https://github.com/user/repo.git -> repo
-	// https://github.com/user/repo -> repo
-	// /path/to/dotfiles -> dotfiles
+// isURL reports whether source is a remote URL rather than a local path
+func isURL(source string) bool {
+	return strings.Contains(source, "://") || strings.HasPrefix(source, "git@")
+}
 
-	// For local paths, use the directory name
-	if !strings.Contains(source, "://") && !strings.HasPrefix(source, "git@") {
-		absPath, err := filepath.Abs(source)
-		if err == nil {
-			source = absPath
+// RepoNames returns the names a source can be installed under, shortest
+// first. The first is the last path segment; each following one adds the
+// segment before it, up to the host of a URL.
+//
+//	https://github.com/alice/dotfiles.git -> dotfiles, alice-dotfiles, github.com-alice-dotfiles
+//	/home/alice/dotfiles                  -> dotfiles, alice-dotfiles, home-alice-dotfiles
+func RepoNames(source string) []string {
+	var parts []string
+	if isURL(source) {
+		rest := source
+		if _, after, ok := strings.Cut(rest, "://"); ok {
+			rest = after
+		} else {
+			// git@host:owner/repo
+			_, rest, _ = strings.Cut(rest, "@")
+			rest = strings.Replace(rest, ":", "/", 1)
 		}
-	}
 
-	parts := strings.Split(source, "/")
-	if len(parts) == 0 {
-		return "dotfiles"
+		// Credentials and ports are no part of the name
+		host, path, _ := strings.Cut(rest, "/")
+		if _, after, ok := strings.Cut(host, "@"); ok {
+			host = after
+		}
+		host, _, _ = strings.Cut(host, ":")
+		parts = append([]string{host}, strings.Split(path, "/")...)
+	} else {
+		parts = strings.Split(filepath.ToSlash(CanonicalSource(source)), "/")
 	}
 
-	name := parts[len(parts)-1]
-	name = strings.TrimSuffix(name, ".git")
-
-	if name == "" {
-		return "dotfiles"
+	parts = slices.DeleteFunc(parts, func(part string) bool { return part == "" })
+	if len(parts) > 0 {
+		parts[len(parts)-1] = strings.TrimSuffix(parts[len(parts)-1], ".git")
+	}
+	if len(parts) == 0 || parts[len(parts)-1] == "" {
+		return []string{"dotfiles"}
 	}
 
-	return name
+	var names []string
+	for n := len(parts) - 1; n >= 0; n-- {
+		names = append(names, strings.Join(parts[n:], "-"))
+	}
+	return names
 }
 
-// copyDir recursively copies a directory
-func copyDir(src, dst string) error {
+// copyDir recursively copies a directory, skipping anything the ignore rules match
+func copyDir(src, dst string, ignore *Ignore) error {
 	// Get source directory info
 	srcInfo, err := os.Stat(src)
 	if err != nil {
@@ -151,6 +419,12 @@ func copyDir(src, dst string) error {
 		return err
 	}
 
+	// Pick up a .godotsignore in this directory
+	ignore, err = ignore.Load(src)
+	if err != nil {
+		return err
+	}
+
 	// Read source directory
 	entries, err := os.ReadDir(src)
 	if err != nil {
@@ -162,9 +436,13 @@ func copyDir(src, dst string) error {
 		srcPath := filepath.Join(src, entry.Name())
 		dstPath := filepath.Join(dst, entry.Name())
 
+		if ignore.Match(srcPath, entry.IsDir()) {
+			continue
+		}
+
 		if entry.IsDir() {
 			// Recursively copy subdirectory
-			if err := copyDir(srcPath, dstPath); err != nil {
+			if err := copyDir(srcPath, dstPath, ignore); err != nil {
 				return err
 			}
 		} else {
@@ -207,3 +485,8 @@ func copyFilePreserveMode(src, dst string) error {
 
 	return nil
 }
+
+// RemoveCache deletes a cached repository
+func (i *Installer) RemoveCache(repoPath string) error {
+	return i.remove(repoPath)
+}
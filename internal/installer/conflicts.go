@@ -0,0 +1,187 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ConflictKind classifies something found where a group will be installed
+type ConflictKind string
+
+const (
+	ConflictForeign   ConflictKind = "foreign"   // File or directory not managed by godots
+	ConflictOwnership ConflictKind = "ownership" // Link into another godots repository
+)
+
+// Conflict is an existing path that installing a group would replace
+type Conflict struct {
+	Group  string
+	Path   string
+	Source string // Repository path that would be installed at Path
+	Kind   ConflictKind
+	Owner  string // Repository an ownership conflict's link points into
+}
+
+// CheckConflicts compares the groups against what is on disk. Links that
+// already point into the same repository are not conflicts, so installing
+// twice does not back up godots' own links.
+func (i *Installer) CheckConflicts(groups []DotfileGroup) ([]Conflict, error) {
+	var conflicts []Conflict
+
+	for _, group := range groups {
+		// Templated groups are linked from their rendered output
+		expected := group.Source
+		if group.Template {
+			output, err := i.renderPath(group)
+			if err != nil {
+				return nil, err
+			}
+			expected = output
+		}
+
+		if group.Strategy == StrategyTree || group.Strategy == StrategyCopy {
+			found, err := i.treeConflicts(group, group.Source, expected, group.Target, group.ignoreRules())
+			if err != nil {
+				return nil, err
+			}
+			conflicts = append(conflicts, found...)
+			continue
+		}
+
+		if conflict, ok := i.checkPath(group, group.Source, expected, group.Target); ok {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	return conflicts, nil
+}
+
+// treeConflicts finds existing files that tree linking or copying would have
+// to replace. Real directories are merged into and managed directory links
+// are unfolded, so neither counts as a conflict.
+func (i *Installer) treeConflicts(group DotfileGroup, source, expected, target string, ignore *Ignore) ([]Conflict, error) {
+	srcInfo, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !srcInfo.IsDir() {
+		if group.Strategy == StrategyCopy && sameContents(source, target) {
+			return nil, nil
+		}
+		if conflict, ok := i.checkPath(group, source, expected, target); ok {
+			return []Conflict{conflict}, nil
+		}
+		return nil, nil
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if dest, err := i.readlink(target); err == nil && i.isManaged(dest) {
+			return nil, nil
+		}
+		if dirInfo, err := os.Stat(target); err != nil || !dirInfo.IsDir() {
+			return []Conflict{{Group: group.Name, Path: target, Source: source, Kind: ConflictForeign}}, nil
+		}
+	} else if !info.IsDir() {
+		return []Conflict{{Group: group.Name, Path: target, Source: source, Kind: ConflictForeign}}, nil
+	}
+
+	ignore, err = ignore.Load(source)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := i.readSourceDir(source, ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []Conflict
+	for _, entry := range entries {
+		// Rendered trees drop alternate and template suffixes
+		childExpected := entry.Path
+		if group.Template {
+			childExpected = filepath.Join(expected, entry.Name)
+		}
+
+		found, err := i.treeConflicts(group, entry.Path, childExpected, filepath.Join(target, entry.Name), ignore)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, found...)
+	}
+
+	return conflicts, nil
+}
+
+// checkPath classifies an existing path at target. It reports false when the
+// path is free or is a link godots may replace in place.
+func (i *Installer) checkPath(group DotfileGroup, source, expected, target string) (Conflict, bool) {
+	info, err := os.Lstat(target)
+	if err != nil {
+		return Conflict{}, false
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if dest, err := i.readlink(target); err == nil && i.isManaged(dest) {
+			owner := i.repoOf(dest)
+			if dest == expected || owner == i.repoOf(group.Source) {
+				return Conflict{}, false
+			}
+			return Conflict{Group: group.Name, Path: target, Source: source, Kind: ConflictOwnership, Owner: owner}, true
+		}
+	}
+
+	return Conflict{Group: group.Name, Path: target, Source: source, Kind: ConflictForeign}, true
+}
+
+// placeLink links target to source. A correct link is left alone and a link
+// into the same repository, such as a previously selected alternate, is replaced.
+func (i *Installer) placeLink(source, target string, links *LinkSet) error {
+	if dest, err := i.readlink(target); err == nil {
+		if dest == i.cachePath(source) {
+			links.Symlinks[target] = source
+			return nil
+		}
+
+		if owner := i.repoOf(dest); owner != "" && owner == i.repoOf(source) {
+			if err := i.remove(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := i.symlink(source, target); err != nil {
+		return err
+	}
+
+	links.Symlinks[target] = source
+	links.Changed++
+	return nil
+}
+
+// sameContents reports whether b is a regular file holding the same data as a
+func sameContents(a, b string) bool {
+	if info, err := os.Lstat(b); err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	hashA, err := hashFile(a)
+	if err != nil {
+		return false
+	}
+	hashB, err := hashFile(b)
+	if err != nil {
+		return false
+	}
+
+	return hashA == hashB
+}
@@ -0,0 +1,263 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// copyTree copies source to target file by file, recording a content hash
+// for every file written so later updates can detect local edits.
+func (i *Installer) copyTree(source, target string, ignore *Ignore, links *LinkSet) error {
+	source = i.resolve(source)
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return i.copyInto(source, target, links)
+	}
+
+	if err := i.ensureTreeDir(target, links); err != nil {
+		return err
+	}
+
+	ignore, err = ignore.Load(source)
+	if err != nil {
+		return err
+	}
+
+	entries, err := i.readSourceDir(source, ignore)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := i.copyTree(entry.Path, filepath.Join(target, entry.Name), ignore, links); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyInto writes a single file and records its hash
+func (i *Installer) copyInto(source, target string, links *LinkSet) error {
+	if err := i.ensureTreeDir(filepath.Dir(target), links); err != nil {
+		return err
+	}
+
+	// Leave identical files alone so reinstalling is a no-op
+	if sameContents(source, target) {
+		hash, err := hashFile(target)
+		if err != nil {
+			return err
+		}
+		links.Copies[target] = hash
+		return nil
+	}
+
+	// Set the previous contents aside so they can be put back
+	if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+		if err := i.remove(target); err != nil {
+			return err
+		}
+	}
+
+	if i.plan != nil {
+		i.act(ActionWrite, target, source)
+		links.Changed++
+		return nil
+	}
+	if err := i.created(target); err != nil {
+		return err
+	}
+
+	hash, err := copyFileHashed(source, target)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s -> %s: %w", source, target, err)
+	}
+
+	links.Copies[target] = hash
+	links.Changed++
+	return nil
+}
+
+// SyncCopies refreshes a copy-strategy group after an update. Files are only
+// overwritten when their on-disk hash still matches what godots last wrote;
+// locally modified copies are left alone and returned.
+func (i *Installer) SyncCopies(group DotfileGroup, links *LinkSet) ([]string, error) {
+	group, err := i.prepareSource(group, links)
+	if err != nil {
+		return nil, err
+	}
+
+	if links.Copies == nil {
+		links.Copies = make(map[string]string)
+	}
+
+	var modified []string
+	err = i.walkCopies(group.Source, group.Target, group.ignoreRules(), func(source, target string) error {
+		current, err := hashFile(target)
+		if os.IsNotExist(err) {
+			return i.copyInto(source, target, links)
+		}
+		if err != nil {
+			return err
+		}
+
+		wanted, err := hashFile(source)
+		if err != nil {
+			return err
+		}
+
+		switch current {
+		case wanted:
+			links.Copies[target] = wanted
+			return nil
+		case links.Copies[target]:
+			return i.copyInto(source, target, links)
+		}
+
+		modified = append(modified, target)
+		return nil
+	})
+	i.describe(group, links)
+
+	return modified, err
+}
+
+// ModifiedCopies returns the recorded copies whose contents changed since godots wrote them
+func (i *Installer) ModifiedCopies(copies map[string]string) []string {
+	var modified []string
+
+	for target, hash := range copies {
+		current, err := hashFile(target)
+		if err == nil && current != hash {
+			modified = append(modified, target)
+		}
+	}
+
+	return modified
+}
+
+// walkCopies calls fn for every file a copy of source into target would write
+func (i *Installer) walkCopies(source, target string, ignore *Ignore, fn func(source, target string) error) error {
+	source = i.resolve(source)
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fn(source, target)
+	}
+
+	ignore, err = ignore.Load(source)
+	if err != nil {
+		return err
+	}
+
+	entries, err := i.readSourceDir(source, ignore)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := i.walkCopies(entry.Path, filepath.Join(target, entry.Name), ignore, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolve follows a symlink created by godots, such as the links to plain
+// files in a rendered tree, which are addressed from inside the root
+func (i *Installer) resolve(path string) string {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return path
+	}
+
+	dest, err := i.readlink(path)
+	if err != nil || !filepath.IsAbs(dest) {
+		return path
+	}
+
+	return dest
+}
+
+// removeCopy deletes a copied file unless it was modified after godots wrote it
+func (i *Installer) removeCopy(target, hash string) error {
+	current, err := hashFile(target)
+	if err != nil || current != hash {
+		return nil // Gone or locally modified, leave it
+	}
+
+	if err := i.remove(target); err != nil {
+		return fmt.Errorf("failed to remove copy %s: %w", target, err)
+	}
+
+	return nil
+}
+
+// copyFileHashed copies src to dst with src's permissions and returns the SHA-256 of the contents
+func copyFileHashed(src, dst string) (string, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Replace rather than write through an existing symlink
+	if info, err := os.Lstat(dst); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(dst); err != nil {
+			return "", err
+		}
+	}
+
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, srcInfo.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+	defer dstFile.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dstFile, hash), srcFile); err != nil {
+		return "", err
+	}
+
+	if err := dstFile.Chmod(srcInfo.Mode().Perm()); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashFile returns the hex SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,160 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LinkEntry describes one link or copy as it was placed on disk
+type LinkEntry struct {
+	Group     string      `toml:"group"`
+	Strategy  Strategy    `toml:"strategy"`
+	Source    string      `toml:"source"`
+	Commit    string      `toml:"commit,omitempty"` // Repository revision the source came from
+	Hash      string      `toml:"hash,omitempty"`   // SHA-256 of the file contents, empty for directories
+	Mode      os.FileMode `toml:"mode"`
+	CreatedAt time.Time   `toml:"created_at"`
+	Backup    string      `toml:"backup,omitempty"` // Backup holding what the entry replaced
+}
+
+// DescribeLink records what source looked like when it was placed at target.
+// Copies are described by the file written, links by what they point to.
+func DescribeLink(group string, strategy Strategy, source, target, commit string) LinkEntry {
+	entry := LinkEntry{
+		Group:     group,
+		Strategy:  strategy,
+		Source:    source,
+		Commit:    commit,
+		CreatedAt: time.Now(),
+	}
+
+	path := source
+	if strategy == StrategyCopy {
+		path = target
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		entry.Mode = info.Mode()
+		if info.Mode().IsRegular() {
+			entry.Hash, _ = hashFile(path)
+		}
+	}
+
+	return entry
+}
+
+// describe adds entries for the links and copies of group that have none or
+// whose recorded entry no longer matches
+func (i *Installer) describe(group DotfileGroup, links *LinkSet) {
+	if links.Entries == nil {
+		links.Entries = make(map[string]LinkEntry)
+	}
+
+	var commit *string
+	headCommit := func() string {
+		if commit == nil {
+			c := HeadCommit(filepath.Join(i.cfg.CacheDir, i.repoOf(group.Source)))
+			commit = &c
+		}
+		return *commit
+	}
+
+	for target, source := range links.Symlinks {
+		if !isWithin(group.Target, target) {
+			continue
+		}
+		if entry, ok := links.Entries[target]; ok && entry.Source == source {
+			continue
+		}
+		links.Entries[target] = DescribeLink(group.Name, group.Strategy, source, target, headCommit())
+	}
+
+	for target, hash := range links.Copies {
+		if !isWithin(group.Target, target) {
+			continue
+		}
+		if entry, ok := links.Entries[target]; ok && entry.Hash == hash {
+			continue
+		}
+
+		rel, err := filepath.Rel(group.Target, target)
+		if err != nil {
+			continue
+		}
+		links.Entries[target] = DescribeLink(group.Name, StrategyCopy, filepath.Join(group.Source, rel), target, headCommit())
+	}
+}
+
+// noteBackups records the backup holding what was at each of paths
+func (l *LinkSet) noteBackups(paths []string, backup string) {
+	for _, path := range paths {
+		if entry, ok := l.Entries[path]; ok {
+			entry.Backup = backup
+			l.Entries[path] = entry
+		}
+	}
+}
+
+// Group returns the entries of one group together with the directories and
+// rendered output that no other group uses
+func (l LinkSet) Group(name string) LinkSet {
+	group := newLinkSet()
+
+	var others []string
+	for target, entry := range l.Entries {
+		if entry.Group != name {
+			others = append(others, target)
+			continue
+		}
+
+		group.Entries[target] = entry
+		if source, ok := l.Symlinks[target]; ok {
+			group.Symlinks[target] = source
+		}
+		if hash, ok := l.Copies[target]; ok {
+			group.Copies[target] = hash
+		}
+	}
+
+	// A directory belongs to the group when only its entries live below it
+	owned := func(dir string) bool {
+		for _, target := range others {
+			if isWithin(dir, target) {
+				return false
+			}
+		}
+		for target := range group.Entries {
+			if isWithin(dir, target) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, dir := range l.Dirs {
+		if owned(dir) {
+			group.Dirs = append(group.Dirs, dir)
+		}
+	}
+	for dir, source := range l.Unfolded {
+		if owned(dir) {
+			group.Unfolded[dir] = source
+		}
+	}
+
+	for _, output := range l.Rendered {
+		for _, entry := range group.Entries {
+			if isWithin(output, entry.Source) {
+				group.Rendered = append(group.Rendered, output)
+				break
+			}
+		}
+	}
+
+	if strategy, ok := l.Strategies[name]; ok {
+		group.Strategies[name] = strategy
+	}
+
+	return group
+}
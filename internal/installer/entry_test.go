@@ -0,0 +1,128 @@
+package installer
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// sampleLinks returns the links of three installed groups: nvim linked as a
+// tree partly from rendered templates, zsh as a symlink and git as a copy.
+// Every source is written below root.
+func sampleLinks(t *testing.T, root string) LinkSet {
+	t.Helper()
+
+	home := filepath.Join(root, "home")
+	cache := filepath.Join(root, "cache", "dots")
+	render := filepath.Join(root, "render", "dots")
+
+	links := newLinkSet()
+	add := func(group string, strategy Strategy, target, source string) {
+		writeFile(t, source, group)
+		links.Entries[target] = LinkEntry{Group: group, Strategy: strategy, Source: source}
+		if strategy == StrategyCopy {
+			links.Copies[target] = "hash"
+		} else {
+			links.Symlinks[target] = source
+		}
+		links.Strategies[group] = strategy
+	}
+
+	add("nvim", StrategyTree, filepath.Join(home, ".config", "nvim", "init.lua"), filepath.Join(render, "config", "nvim", "init.lua"))
+	add("nvim", StrategyTree, filepath.Join(home, ".config", "nvim", "lua", "plugins.lua"), filepath.Join(cache, "config", "nvim", "lua", "plugins.lua"))
+	add("zsh", StrategySymlink, filepath.Join(home, ".zshrc"), filepath.Join(cache, "zsh", ".zshrc"))
+	add("git", StrategyCopy, filepath.Join(home, ".config", "git", "config"), filepath.Join(cache, "config", "git", "config"))
+
+	links.Dirs = []string{
+		filepath.Join(home, ".config"),
+		filepath.Join(home, ".config", "nvim"),
+		filepath.Join(home, ".config", "nvim", "lua"),
+		filepath.Join(home, ".config", "git"),
+	}
+	links.Unfolded[filepath.Join(home, ".config", "nvim")] = filepath.Join(cache, "config", "nvim")
+	links.Rendered = []string{render}
+
+	return links
+}
+
+// linkPaths lists what a link set holds, relative to root, for comparison
+type linkPaths struct {
+	Symlinks, Copies, Dirs, Unfolded, Rendered []string
+}
+
+func pathsOf(t *testing.T, root string, links LinkSet) linkPaths {
+	t.Helper()
+
+	rel := func(paths []string) []string {
+		var list []string
+		for _, path := range paths {
+			r, err := filepath.Rel(root, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			list = append(list, filepath.ToSlash(r))
+		}
+		slices.Sort(list)
+		return list
+	}
+
+	return linkPaths{
+		Symlinks: rel(slices.Collect(maps.Keys(links.Symlinks))),
+		Copies:   rel(slices.Collect(maps.Keys(links.Copies))),
+		Dirs:     rel(links.Dirs),
+		Unfolded: rel(slices.Collect(maps.Keys(links.Unfolded))),
+		Rendered: rel(links.Rendered),
+	}
+}
+
+func (p linkPaths) equal(other linkPaths) bool {
+	return slices.Equal(p.Symlinks, other.Symlinks) &&
+		slices.Equal(p.Copies, other.Copies) &&
+		slices.Equal(p.Dirs, other.Dirs) &&
+		slices.Equal(p.Unfolded, other.Unfolded) &&
+		slices.Equal(p.Rendered, other.Rendered)
+}
+
+func TestLinkSetGroup(t *testing.T) {
+	tests := []struct {
+		group string
+		want  linkPaths
+	}{
+		{"nvim", linkPaths{
+			Symlinks: []string{"home/.config/nvim/init.lua", "home/.config/nvim/lua/plugins.lua"},
+			Dirs:     []string{"home/.config/nvim", "home/.config/nvim/lua"},
+			Unfolded: []string{"home/.config/nvim"},
+			Rendered: []string{"render/dots"},
+		}},
+		{"zsh", linkPaths{
+			Symlinks: []string{"home/.zshrc"},
+		}},
+		{"git", linkPaths{
+			Copies: []string{"home/.config/git/config"},
+			Dirs:   []string{"home/.config/git"},
+		}},
+		{"missing", linkPaths{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			root := t.TempDir()
+			links := sampleLinks(t, root)
+
+			group := links.Group(tt.group)
+			if got := pathsOf(t, root, group); !got.equal(tt.want) {
+				t.Errorf("Group(%q) = %+v, want %+v", tt.group, got, tt.want)
+			}
+
+			for target, entry := range group.Entries {
+				if entry.Group != tt.group {
+					t.Errorf("Group(%q) holds %s of group %q", tt.group, target, entry.Group)
+				}
+			}
+			if _, ok := group.Strategies[tt.group]; ok != (tt.group != "missing") || len(group.Strategies) > 1 {
+				t.Errorf("Group(%q) strategies = %v", tt.group, group.Strategies)
+			}
+		})
+	}
+}
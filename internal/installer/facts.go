@@ -0,0 +1,105 @@
+package installer
+
+import (
+	"bufio"
+	"os"
+	"os/user"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Facts describes the machine that alternates and templates are resolved against
+type Facts struct {
+	Host    string   `toml:"host"`
+	OS      string   `toml:"os"`
+	Distro  string   `toml:"distro"`
+	Arch    string   `toml:"arch"`
+	User    string   `toml:"user"`
+	Classes []string `toml:"classes"`
+}
+
+// Facts gathers facts about the current machine. Classes come from the
+// godots config file and the comma-separated GODOTS_CLASS variable.
+func (i *Installer) Facts() Facts {
+	if i.facts != nil {
+		return *i.facts
+	}
+
+	facts := Facts{
+		OS:     runtime.GOOS,
+		Distro: readDistro(i.cfg.Reroot("/etc/os-release")),
+		Arch:   runtime.GOARCH,
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		facts.Host = host
+	}
+	if u, err := user.Current(); err == nil {
+		facts.User = u.Username
+	}
+
+	facts.Classes = append(facts.Classes, i.cfg.Settings.Classes...)
+	for _, class := range strings.Split(os.Getenv("GODOTS_CLASS"), ",") {
+		if class = strings.TrimSpace(class); class != "" && !slices.Contains(facts.Classes, class) {
+			facts.Classes = append(facts.Classes, class)
+		}
+	}
+
+	i.facts = &facts
+	return facts
+}
+
+// Recorded reports whether the facts were gathered at all. Manifests from
+// before facts were recorded have none, which says nothing about a change.
+func (f Facts) Recorded() bool {
+	return f.OS != ""
+}
+
+// Equal reports whether two sets of facts would resolve alternates the same way
+func (f Facts) Equal(other Facts) bool {
+	return f.Host == other.Host &&
+		f.OS == other.OS &&
+		f.Distro == other.Distro &&
+		f.Arch == other.Arch &&
+		f.User == other.User &&
+		slices.Equal(f.Classes, other.Classes)
+}
+
+// Matches reports whether a single alternate condition such as "host.laptop" holds
+func (f Facts) Matches(key, value string) bool {
+	switch key {
+	case "host", "hostname":
+		return f.Host == value
+	case "os":
+		return strings.EqualFold(f.OS, value) || strings.EqualFold(f.Distro, value)
+	case "distro":
+		return strings.EqualFold(f.Distro, value)
+	case "arch":
+		return f.Arch == value
+	case "user":
+		return f.User == value
+	case "class":
+		return slices.Contains(f.Classes, value)
+	}
+
+	return false
+}
+
+// readDistro returns the ID field of an os-release file
+func readDistro(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id, ok := strings.CutPrefix(scanner.Text(), "ID="); ok {
+			return strings.Trim(id, `"'`)
+		}
+	}
+
+	return ""
+}
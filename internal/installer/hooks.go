@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 )
 
 type Hook struct {
@@ -49,17 +50,40 @@ func (i *Installer) DiscoverHooks(repoPath string) ([]Hook, error) {
 }
 
 func (i *Installer) RunHooks(hooks []Hook, silent bool) error {
+	// Silent runs keep hook output in the log directory instead of discarding it
+	var log *os.File
+	if silent {
+		f, err := os.OpenFile(filepath.Join(i.cfg.LogDir, "hooks.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open hook log: %w", err)
+		}
+		defer f.Close()
+		log = f
+	}
+
 	for _, hook := range hooks {
 		if !silent {
 			fmt.Printf("🔄 Running hook: %s\n", hook.Name)
+		} else {
+			fmt.Fprintf(log, "==> %s %s\n", time.Now().Format(time.RFC3339), hook.Path)
 		}
 
 		cmd := exec.Command("bash", hook.Path)
 		cmd.Dir = i.cfg.HomeDir
+		cmd.Env = append(os.Environ(),
+			"HOME="+i.cfg.HomeDir,
+			"XDG_CONFIG_HOME="+i.cfg.ConfigHome,
+			"XDG_CACHE_HOME="+i.cfg.CacheHome,
+			"XDG_DATA_HOME="+i.cfg.DataHome,
+			"XDG_STATE_HOME="+i.cfg.StateHome,
+		)
 
 		if !silent {
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
+		} else {
+			cmd.Stdout = log
+			cmd.Stderr = log
 		}
 
 		if err := cmd.Run(); err != nil {
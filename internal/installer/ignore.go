@@ -0,0 +1,204 @@
+package installer
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile is the gitignore-style file honored in the repo root and any subdirectory
+const IgnoreFile = ".godotsignore"
+
+// defaultIgnores are editor and OS leftovers that are never worth copying
+var defaultIgnores = []string{
+	".DS_Store",
+	"Thumbs.db",
+	"*.swp",
+	"*.swo",
+	"*~",
+	".#*",
+	"#*#",
+}
+
+// linkIgnores are repository files that never belong in the target directory
+var linkIgnores = []string{
+	IgnoreFile,
+	RepoConfigFile,
+	SourceFile,
+	".git",
+	"README*",
+	"LICENSE*",
+}
+
+type ignoreRule struct {
+	base     string // Directory the pattern is relative to, empty for built-ins
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Ignore matches paths against built-in patterns and any .godotsignore files loaded so far
+type Ignore struct {
+	rules []ignoreRule
+}
+
+func newIgnore(patterns ...[]string) *Ignore {
+	ig := &Ignore{}
+	for _, list := range patterns {
+		for _, p := range list {
+			if rule, ok := parseIgnoreRule("", p); ok {
+				ig.rules = append(ig.rules, rule)
+			}
+		}
+	}
+	return ig
+}
+
+// linkIgnore returns the built-in rules used when scanning and linking
+func linkIgnore() *Ignore {
+	return newIgnore(defaultIgnores, linkIgnores)
+}
+
+// copyIgnore returns the built-in rules used when copying a local source into the cache
+func copyIgnore() *Ignore {
+	return newIgnore(defaultIgnores)
+}
+
+// Load returns a copy of ig extended with the rules from dir/.godotsignore, if present
+func (ig *Ignore) Load(dir string) (*Ignore, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return ig, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	child := &Ignore{rules: append([]ignoreRule(nil), ig.rules...)}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(dir, scanner.Text()); ok {
+			child.rules = append(child.rules, rule)
+		}
+	}
+
+	return child, scanner.Err()
+}
+
+// LoadPath loads the ignore files of every directory below root down to and including dir
+func (ig *Ignore) LoadPath(root, dir string) (*Ignore, error) {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return ig, err
+	}
+
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		if ig, err = ig.Load(current); err != nil {
+			return nil, err
+		}
+	}
+
+	return ig, nil
+}
+
+// Match reports whether path should be ignored. As in gitignore, the last matching rule wins.
+func (ig *Ignore) Match(name string, isDir bool) bool {
+	ignored := false
+
+	for _, rule := range ig.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.matches(name) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
+
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	// Patterns containing a slash are relative to the ignore file's directory
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	rule.pattern = line
+	return rule, true
+}
+
+func (r ignoreRule) matches(name string) bool {
+	if r.base == "" {
+		matched, _ := path.Match(r.pattern, filepath.Base(name))
+		return matched
+	}
+
+	rel, err := filepath.Rel(r.base, name)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	if !r.anchored {
+		matched, _ := path.Match(r.pattern, path.Base(rel))
+		return matched
+	}
+
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches a slash-separated pattern where ** spans any number of segments
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(parts); skip++ {
+				if matchSegments(pattern[1:], parts[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+			return false
+		}
+
+		pattern, parts = pattern[1:], parts[1:]
+	}
+
+	return len(parts) == 0
+}
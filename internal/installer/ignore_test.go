@@ -0,0 +1,88 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreMatch(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "nvim"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(root, IgnoreFile):         "*.log\n# comment\n\n!keep.log\nbuild/\n/secrets\ncache/**/tmp\n",
+		filepath.Join(root, "nvim", IgnoreFile): "lazy-lock.json\n\\!bang\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// As the scanner does: the root's file, then those down to the group
+	ig, err := linkIgnore().Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ig, err = ig.LoadPath(root, filepath.Join(root, "nvim")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"built-in swap file", "nvim/init.lua.swp", false, true},
+		{"built-in link ignore", "README.md", false, true},
+		{"plain file", "nvim/init.lua", false, false},
+		{"glob at any depth", "nvim/debug.log", false, true},
+		{"negation wins when last", "keep.log", false, false},
+		{"directory-only rule on dir", "build", true, true},
+		{"directory-only rule on file", "build", false, false},
+		{"anchored at its directory", "secrets", false, true},
+		{"anchored not below", "nvim/secrets", false, false},
+		{"double star with no segments", "cache/tmp", true, true},
+		{"double star with segments", "cache/a/b/tmp", true, true},
+		{"double star other name", "cache/a/keep", true, false},
+		{"nested ignore file", "nvim/lazy-lock.json", false, true},
+		{"nested rule outside its directory", "lazy-lock.json", false, false},
+		{"escaped bang", "nvim/!bang", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ig.Match(filepath.Join(root, tt.path), tt.isDir); got != tt.want {
+				t.Errorf("Match(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIgnoreRule(t *testing.T) {
+	tests := []struct {
+		line string
+		want ignoreRule
+		ok   bool
+	}{
+		{"", ignoreRule{}, false},
+		{"# comment", ignoreRule{}, false},
+		{"/", ignoreRule{}, false},
+		{"*.log  ", ignoreRule{pattern: "*.log"}, true},
+		{"!keep", ignoreRule{pattern: "keep", negate: true}, true},
+		{`\#hash`, ignoreRule{pattern: "#hash"}, true},
+		{"build/", ignoreRule{pattern: "build", dirOnly: true}, true},
+		{"/top", ignoreRule{pattern: "top", anchored: true}, true},
+		{"a/**/b/", ignoreRule{pattern: "a/**/b", anchored: true, dirOnly: true}, true},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseIgnoreRule("", tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseIgnoreRule(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -9,15 +9,25 @@ import (
 )
 
 type Installer struct {
-	cfg *config.Config
+	cfg      *config.Config
+	facts    *Facts
+	strategy Strategy
+	journal  *Journal // Transaction in progress, if any
+	plan     *Plan    // Plan being made, changes are recorded instead of made
+	preview  *Preview // Contents standing in for a repository's cache while planning
 }
 
 func New(cfg *config.Config) *Installer {
 	return &Installer{cfg: cfg}
 }
 
+// SetStrategy overrides the repo-wide strategy for groups without their own setting
+func (i *Installer) SetStrategy(strategy Strategy) {
+	i.strategy = strategy
+}
+
 func (i *Installer) SetupPacmanHook() error {
-	hookDir := filepath.Join(i.cfg.HomeDir, ".config", "pacman", "hooks")
+	hookDir := filepath.Join(i.cfg.ConfigHome, "pacman", "hooks")
 	if err := os.MkdirAll(hookDir, 0755); err != nil {
 		return err
 	}
@@ -0,0 +1,337 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// JournalFile holds the steps of the operation in progress
+const JournalFile = "journal.toml"
+
+// StepKind is the kind of filesystem change a journal step records
+type StepKind string
+
+const (
+	StepCreate StepKind = "create" // Path was created; undone by removing it
+	StepRemove StepKind = "remove" // Path was moved aside to Stash; undone by moving it back
+	StepBackup StepKind = "backup" // Path was moved into backup Backup; undone by restoring it
+	StepMove   StepKind = "move"   // Path was moved to Source; undone by moving it back
+	StepClone  StepKind = "clone"  // Repository cached at Path; undone by removing it entirely
+	StepPull   StepKind = "pull"   // Repository at Path moved on from Commit; undone by resetting it
+)
+
+// Step is one filesystem change made during an operation
+type Step struct {
+	Kind   StepKind `toml:"kind"`
+	Path   string   `toml:"path"`
+	Source string   `toml:"source,omitempty"`
+	Stash  string   `toml:"stash,omitempty"`
+	Backup string   `toml:"backup,omitempty"`
+	Commit string   `toml:"commit,omitempty"`
+}
+
+// Journal records every change an operation makes so it can be rolled back.
+// Removed paths are kept aside until the operation commits. A journal left
+// on disk belongs to an operation that was interrupted.
+type Journal struct {
+	Operation string    `toml:"operation"`
+	Repo      string    `toml:"repo"`
+	Command   []string  `toml:"command"` // Arguments that started the operation, to resume it
+	PID       int       `toml:"pid"`
+	StartedAt time.Time `toml:"started_at"`
+	Steps     []Step    `toml:"steps"`
+}
+
+// mapPaths returns a copy of the journal with the paths of its steps passed
+// through fn. They are stored as seen from inside the configured root.
+func (j Journal) mapPaths(fn func(string) string) Journal {
+	steps := make([]Step, len(j.Steps))
+	for n, step := range j.Steps {
+		step.Path = fn(step.Path)
+		step.Source = fn(step.Source)
+		step.Stash = fn(step.Stash)
+		steps[n] = step
+	}
+	j.Steps = steps
+	return j
+}
+
+// Begin starts a transaction for the command with the given arguments. Until
+// Commit or Rollback every change the installer makes is journaled.
+func (i *Installer) Begin(operation, repo string, command []string) error {
+	if i.journal != nil {
+		return fmt.Errorf("a %s of %s is already in progress", i.journal.Operation, i.journal.Repo)
+	}
+
+	if _, err := os.Stat(i.journalPath()); err == nil {
+		return fmt.Errorf("an unfinished operation was found in %s", i.cfg.JournalDir)
+	}
+
+	if err := os.MkdirAll(i.cfg.JournalDir, 0755); err != nil {
+		return fmt.Errorf("failed to create journal: %w", err)
+	}
+
+	i.journal = &Journal{
+		Operation: operation,
+		Repo:      repo,
+		Command:   command,
+		PID:       os.Getpid(),
+		StartedAt: time.Now(),
+	}
+
+	return i.saveJournal()
+}
+
+// Interrupted returns the journal of an operation that never finished, or
+// nil. Callers hold the state lock, so its process is gone.
+func (i *Installer) Interrupted() (*Journal, error) {
+	var journal Journal
+	if _, err := toml.DecodeFile(i.journalPath(), &journal); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	journal = journal.mapPaths(i.cfg.Reroot)
+	return &journal, nil
+}
+
+// Resume continues an interrupted operation as the transaction in progress,
+// so its earlier steps are committed or rolled back along with new ones
+func (i *Installer) Resume(journal *Journal) error {
+	if i.journal != nil {
+		return fmt.Errorf("a %s of %s is already in progress", i.journal.Operation, i.journal.Repo)
+	}
+
+	i.journal = journal
+	i.journal.PID = os.Getpid()
+	return i.saveJournal()
+}
+
+// Commit ends the transaction, keeping its changes and discarding what was moved aside
+func (i *Installer) Commit() error {
+	if i.journal == nil {
+		return nil
+	}
+
+	i.journal = nil
+	if err := os.RemoveAll(i.cfg.JournalDir); err != nil {
+		return fmt.Errorf("failed to clear journal: %w", err)
+	}
+
+	return nil
+}
+
+// Rollback undoes the steps of the transaction in reverse and returns how
+// many were undone. When a step cannot be undone the journal and anything
+// moved aside are kept so nothing is lost.
+func (i *Installer) Rollback() (int, error) {
+	journal := i.journal
+	if journal == nil {
+		return 0, nil
+	}
+
+	// Undoing must not be journaled itself
+	i.journal = nil
+
+	var failures []error
+	for n := len(journal.Steps) - 1; n >= 0; n-- {
+		if err := i.undo(journal.Steps[n]); err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return len(journal.Steps) - len(failures), fmt.Errorf("rollback incomplete, journal kept in %s: %w", i.cfg.JournalDir, errors.Join(failures...))
+	}
+
+	if err := os.RemoveAll(i.cfg.JournalDir); err != nil {
+		return len(journal.Steps), fmt.Errorf("failed to clear journal: %w", err)
+	}
+
+	return len(journal.Steps), nil
+}
+
+func (i *Installer) undo(step Step) error {
+	switch step.Kind {
+	case StepCreate:
+		// Directories are only removed once empty, their contents are undone first
+		if err := os.Remove(step.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", step.Path, err)
+		}
+
+	case StepRemove:
+		// The move may never have happened
+		if _, err := os.Lstat(step.Stash); os.IsNotExist(err) {
+			return nil
+		}
+		if err := movePath(step.Stash, step.Path); err != nil {
+			return fmt.Errorf("failed to put back %s: %w", step.Path, err)
+		}
+
+	case StepMove:
+		if err := movePath(step.Source, step.Path); err != nil {
+			return fmt.Errorf("failed to move back %s: %w", step.Path, err)
+		}
+
+	case StepClone:
+		if err := os.RemoveAll(step.Path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", step.Path, err)
+		}
+
+	case StepPull:
+		if _, err := os.Stat(step.Path); os.IsNotExist(err) {
+			return nil
+		}
+		if _, err := gitOutput(step.Path, "reset", "-q", "--hard", step.Commit); err != nil {
+			return fmt.Errorf("failed to reset %s to %s: %w", step.Path, step.Commit, err)
+		}
+
+	case StepBackup:
+		if err := i.undoBackup(step.Backup, step.Path); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", step.Path, err)
+		}
+
+	default:
+		return fmt.Errorf("unknown journal step %q", step.Kind)
+	}
+
+	return nil
+}
+
+// record appends a step to the journal of the transaction in progress
+func (i *Installer) record(step Step) error {
+	if i.journal == nil {
+		return nil
+	}
+
+	i.journal.Steps = append(i.journal.Steps, step)
+	return i.saveJournal()
+}
+
+// created records a path the current operation made
+func (i *Installer) created(path string) error {
+	return i.record(Step{Kind: StepCreate, Path: path})
+}
+
+// remove deletes path. Inside a transaction it is moved aside instead, so a
+// rollback can put it back.
+func (i *Installer) remove(path string) error {
+	if i.plan != nil {
+		if i.exists(path) {
+			i.act(ActionRemove, path, "")
+		}
+		return nil
+	}
+
+	if i.journal == nil {
+		return os.RemoveAll(path)
+	}
+
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	stash := filepath.Join(i.cfg.JournalDir, "stash", strconv.Itoa(len(i.journal.Steps)))
+	if err := os.MkdirAll(filepath.Dir(stash), 0755); err != nil {
+		return err
+	}
+
+	// Journal the move first, a crash in between must not lose track of path
+	if err := i.record(Step{Kind: StepRemove, Path: path, Stash: stash}); err != nil {
+		return err
+	}
+
+	return movePath(path, stash)
+}
+
+// removeDir removes dir only when it is empty
+func (i *Installer) removeDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	for _, entry := range entries {
+		if i.exists(filepath.Join(dir, entry.Name())) {
+			return nil
+		}
+	}
+
+	return i.remove(dir)
+}
+
+// mkdir creates a single directory and records it
+func (i *Installer) mkdir(dir string, perm os.FileMode) error {
+	if i.plan != nil {
+		i.act(ActionMkdir, dir, "")
+		return nil
+	}
+
+	if err := os.Mkdir(dir, perm); err != nil {
+		return err
+	}
+
+	return i.created(dir)
+}
+
+// mkdirAll creates dir and any missing parents, recording each one created
+func (i *Installer) mkdirAll(dir string) error {
+	if i.planned(dir) {
+		return nil
+	}
+
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s exists and is not a directory", dir)
+		}
+		return nil
+	}
+
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := i.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+
+	return i.mkdir(dir, 0755)
+}
+
+func (i *Installer) journalPath() string {
+	return filepath.Join(i.cfg.JournalDir, JournalFile)
+}
+
+// saveJournal replaces the journal file in one step, so a crash leaves
+// either the old or the new journal behind
+func (i *Installer) saveJournal() error {
+	tmp := i.journalPath() + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	err = toml.NewEncoder(f).Encode(i.journal.mapPaths(i.cfg.Unroot))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, i.journalPath())
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	return nil
+}
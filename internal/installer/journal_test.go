@@ -0,0 +1,192 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grainedlotus515/godotctl/internal/config"
+)
+
+// newTestInstaller returns an installer whose home and storage live in a
+// temporary directory
+func newTestInstaller(t *testing.T) (*Installer, string) {
+	t.Helper()
+
+	home := t.TempDir()
+	cfg, err := config.New(config.Options{Home: home})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(cfg), home
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ""
+		}
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, i *Installer, home string)
+		steps   int
+		wantErr bool
+		want    map[string]string // Path relative to home and its contents after rollback, empty when it must not exist
+	}{
+		{
+			name: "created directories go after their contents",
+			setup: func(t *testing.T, i *Installer, home string) {
+				if err := i.mkdirAll(filepath.Join(home, "a", "b", "c")); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(home, "a", "b", "c", "f"), "new")
+				if err := i.created(filepath.Join(home, "a", "b", "c", "f")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			steps: 4,
+			want:  map[string]string{"a": ""},
+		},
+		{
+			name: "removed paths are put back",
+			setup: func(t *testing.T, i *Installer, home string) {
+				if err := i.remove(filepath.Join(home, "old")); err != nil {
+					t.Fatal(err)
+				}
+				if readFile(t, filepath.Join(home, "old")) != "" {
+					t.Fatal("remove left the path in place")
+				}
+			},
+			steps: 1,
+			want:  map[string]string{"old": "kept"},
+		},
+		{
+			name: "a replaced path is removed before the original returns",
+			setup: func(t *testing.T, i *Installer, home string) {
+				if err := i.remove(filepath.Join(home, "old")); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(home, "old"), "new")
+				if err := i.created(filepath.Join(home, "old")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			steps: 2,
+			want:  map[string]string{"old": "kept"},
+		},
+		{
+			name: "moves are undone",
+			setup: func(t *testing.T, i *Installer, home string) {
+				from, to := filepath.Join(home, "old"), filepath.Join(home, "moved")
+				if err := i.record(Step{Kind: StepMove, Path: from, Source: to}); err != nil {
+					t.Fatal(err)
+				}
+				if err := movePath(from, to); err != nil {
+					t.Fatal(err)
+				}
+			},
+			steps: 1,
+			want:  map[string]string{"old": "kept", "moved": ""},
+		},
+		{
+			name: "a step that cannot be undone keeps the journal",
+			setup: func(t *testing.T, i *Installer, home string) {
+				if err := i.mkdirAll(filepath.Join(home, "dir")); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(home, "dir", "unrecorded"), "user")
+				if err := i.remove(filepath.Join(home, "old")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			steps:   1,
+			wantErr: true,
+			want:    map[string]string{"old": "kept", "dir/unrecorded": "user"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, home := newTestInstaller(t)
+			writeFile(t, filepath.Join(home, "old"), "kept")
+
+			if err := i.Begin("install", "dots", nil); err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(t, i, home)
+
+			undone, err := i.Rollback()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rollback() error = %v, want error %v", err, tt.wantErr)
+			}
+			if undone != tt.steps {
+				t.Errorf("Rollback() undid %d steps, want %d", undone, tt.steps)
+			}
+
+			for rel, content := range tt.want {
+				path := filepath.Join(home, rel)
+				if content == "" {
+					if _, err := os.Lstat(path); !os.IsNotExist(err) {
+						t.Errorf("%s still exists", rel)
+					}
+				} else if got := readFile(t, path); got != content {
+					t.Errorf("%s = %q, want %q", rel, got, content)
+				}
+			}
+
+			journal, err := i.Interrupted()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (journal != nil) != tt.wantErr {
+				t.Errorf("journal kept = %v, want %v", journal != nil, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBeginRefusesInterrupted(t *testing.T) {
+	i, home := newTestInstaller(t)
+
+	if err := i.Begin("install", "dots", []string{"install", "dots"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.created(filepath.Join(home, "f")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process finds the journal the first one left
+	other := New(i.cfg)
+	if err := other.Begin("update", "dots", nil); err == nil {
+		t.Fatal("Begin() succeeded over an interrupted operation")
+	}
+
+	journal, err := other.Interrupted()
+	if err != nil || journal == nil {
+		t.Fatalf("Interrupted() = %v, %v, want the journal", journal, err)
+	}
+	if journal.Operation != "install" || len(journal.Steps) != 1 || journal.Steps[0].Path != filepath.Join(home, "f") {
+		t.Errorf("Interrupted() = %+v, want the install with its step", journal)
+	}
+}
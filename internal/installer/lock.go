@@ -0,0 +1,81 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/grainedlotus515/godotctl/internal/config"
+)
+
+// LockedError reports that another process holds the state lock
+type LockedError struct {
+	PID int // Zero when the holder has not written its pid yet
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return "another godotctl is running"
+	}
+	return fmt.Sprintf("another godotctl is running (pid %d)", e.PID)
+}
+
+// StateLock is the exclusive lock held by commands that change installed
+// state, so that they never run concurrently. The operating system releases
+// it when the process exits, however it exits.
+type StateLock struct {
+	f *os.File
+}
+
+// AcquireLock takes the state lock. When another process holds it, it
+// returns a *LockedError, or with wait blocks until the lock is free.
+func AcquireLock(cfg *config.Config, wait bool) (*StateLock, error) {
+	f, err := os.OpenFile(cfg.LockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock: %w", err)
+	}
+
+	locked, err := lockFile(f, wait)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", cfg.LockPath, err)
+	}
+	if !locked {
+		pid := lockHolder(f)
+		f.Close()
+		return nil, &LockedError{PID: pid}
+	}
+
+	// Leave our pid for whoever finds the lock taken
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &StateLock{f: f}, nil
+}
+
+// Release gives up the lock. The lock file stays, removing it would let two
+// processes lock different files.
+func (l *StateLock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+
+	err := unlockFile(l.f)
+	l.f.Close()
+	l.f = nil
+	return err
+}
+
+// lockHolder reads the pid the holder of the lock wrote into it
+func lockHolder(f *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package installer
+
+import "os"
+
+// lockFile always succeeds where flock is unavailable
+func lockFile(f *os.File, wait bool) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,242 @@
+package installer
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ActionKind is the kind of change a plan makes
+type ActionKind string
+
+const (
+	ActionLink   ActionKind = "link"   // Create a symlink at Path pointing to Source
+	ActionMkdir  ActionKind = "mkdir"  // Create the directory Path
+	ActionWrite  ActionKind = "write"  // Write Path with the contents of Source
+	ActionRender ActionKind = "render" // Render the templates of Source into Path
+	ActionRemove ActionKind = "remove" // Remove Path
+	ActionBackup ActionKind = "backup" // Move Path into a backup
+	ActionAdopt  ActionKind = "adopt"  // Move Path into the repository at Source
+)
+
+// Action is one change to the filesystem
+type Action struct {
+	Kind   ActionKind `json:"kind"`
+	Path   string     `json:"path"`
+	Source string     `json:"source,omitempty"`
+}
+
+// Plan describes everything an operation will do. It is made by running the
+// operation with every change recorded instead of made, and carried out by
+// Execute, which runs the same operation for real once it is sure to make
+// the same changes.
+type Plan struct {
+	Operation string   `json:"operation"`
+	Repo      string   `json:"repo"`
+	Fetch     string   `json:"fetch,omitempty"`   // What happens to the cached repository
+	Commits   []string `json:"commits,omitempty"` // Commits the fetch brings in
+	Groups    []string `json:"groups,omitempty"`
+	Skipped   []string `json:"skipped,omitempty"`
+	Actions   []Action `json:"actions"`
+	Hooks     []string `json:"hooks,omitempty"`
+	Manifest  []string `json:"manifest,omitempty"` // Changes to the manifest
+
+	Links     LinkSet `json:"-"` // What the operation leaves installed
+	BackupDir string  `json:"-"` // Set by Execute when files were backed up
+
+	run     func() (LinkSet, error)
+	seen    map[Action]bool
+	removed []string
+	renders map[string]string // Template source -> render output
+}
+
+// Plan runs an operation without touching the filesystem and returns what it would do
+func (i *Installer) Plan(operation, repo string, run func() (LinkSet, error)) (*Plan, error) {
+	plan := &Plan{
+		Operation: operation,
+		Repo:      repo,
+		Actions:   []Action{},
+		run:       run,
+		seen:      make(map[Action]bool),
+		renders:   make(map[string]string),
+	}
+
+	i.plan = plan
+	defer func() { i.plan = nil }()
+
+	links, err := run()
+	if err != nil {
+		return nil, err
+	}
+	plan.Links = links
+
+	// Point links at the rendered output rather than the templates
+	for source, output := range plan.renders {
+		plan.relocate(source, output, true)
+	}
+
+	// Show previewed contents where they will be cached
+	if i.preview != nil {
+		plan.relocate(i.preview.Contents, i.preview.RepoPath, false)
+	}
+
+	return plan, nil
+}
+
+// Execute carries out a plan. The operation is planned again first and
+// nothing is done when that no longer yields the same actions, as the
+// filesystem changed since the plan was made or shown.
+func (i *Installer) Execute(plan *Plan) (LinkSet, error) {
+	current, err := i.Plan(plan.Operation, plan.Repo, plan.run)
+	if err != nil {
+		return LinkSet{}, err
+	}
+	if !maps.Equal(current.seen, plan.seen) {
+		return LinkSet{}, fmt.Errorf("the filesystem changed since the %s was planned, nothing was done; run it again", plan.Operation)
+	}
+
+	return plan.run()
+}
+
+// PlanInstall plans resolving conflicts for repo and then linking groups
+func (i *Installer) PlanInstall(repo string, groups []DotfileGroup, conflicts []Conflict, resolutions map[string]Resolution) (*Plan, error) {
+	var plan *Plan
+
+	plan, err := i.Plan("install", repo, func() (LinkSet, error) {
+		backupDir, err := i.ResolveConflicts(repo, conflicts, resolutions)
+		if err != nil {
+			return LinkSet{}, fmt.Errorf("failed to resolve conflicts: %w", err)
+		}
+		// Only set once the plan exists, i.e. when it is executed
+		if plan != nil {
+			plan.BackupDir = backupDir
+		}
+
+		links, err := i.CreateSymlinks(groups)
+		if err != nil {
+			return links, fmt.Errorf("failed to create symlinks: %w", err)
+		}
+
+		if backupDir != "" {
+			var backedUp []string
+			for _, conflict := range conflicts {
+				switch resolutions[conflict.Path] {
+				case ResolveSkip, ResolveOverwrite, ResolveAdopt:
+				default:
+					backedUp = append(backedUp, conflict.Path)
+				}
+			}
+			links.noteBackups(backedUp, filepath.Base(backupDir))
+		}
+
+		return links, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		plan.Groups = append(plan.Groups, group.Name)
+	}
+
+	return plan, nil
+}
+
+// PlanUninstall plans removing everything in links and the cached repository
+func (i *Installer) PlanUninstall(repo string, links LinkSet, repoPath string) (*Plan, error) {
+	return i.Plan("uninstall", repo, func() (LinkSet, error) {
+		if err := i.RemoveSymlinks(links); err != nil {
+			return LinkSet{}, err
+		}
+
+		if err := i.RemoveCache(repoPath); err != nil {
+			return LinkSet{}, fmt.Errorf("failed to remove cache: %w", err)
+		}
+
+		return newLinkSet(), nil
+	})
+}
+
+// PlanUninstallGroups plans removing the given groups of repo, keeping its
+// other groups and the cached repository. The plan's links are what remains.
+func (i *Installer) PlanUninstallGroups(repo string, links LinkSet, groups []string) (*Plan, error) {
+	removed := newLinkSet()
+	for _, name := range groups {
+		removed.Merge(links.Group(name))
+	}
+
+	return i.Plan("uninstall", repo, func() (LinkSet, error) {
+		if err := i.RemoveSymlinks(removed); err != nil {
+			return LinkSet{}, err
+		}
+
+		remaining := links.Clone()
+		remaining.Remove(removed)
+		return remaining, nil
+	})
+}
+
+// relocate rewrites paths below from to lie below to instead, optionally
+// dropping template suffixes
+func (p *Plan) relocate(from, to string, rendered bool) {
+	move := func(path string) string {
+		if !isWithin(from, path) {
+			return path
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return path
+		}
+		if rendered {
+			rel = strings.TrimSuffix(rel, TemplateSuffix)
+		}
+		return filepath.Join(to, rel)
+	}
+
+	for n, action := range p.Actions {
+		// Keep the template itself as the source of its render
+		if !rendered || action.Kind != ActionRender {
+			p.Actions[n].Source = move(action.Source)
+		}
+		p.Actions[n].Path = move(action.Path)
+	}
+}
+
+// act records an action of the plan being made, once
+func (i *Installer) act(kind ActionKind, path, source string) {
+	action := Action{Kind: kind, Path: path, Source: source}
+	if i.plan.seen[action] {
+		return
+	}
+	i.plan.seen[action] = true
+	i.plan.Actions = append(i.plan.Actions, action)
+
+	if kind == ActionRemove {
+		i.plan.removed = append(i.plan.removed, path)
+	}
+}
+
+// planned reports whether the plan being made creates dir
+func (i *Installer) planned(dir string) bool {
+	return i.plan != nil && i.plan.seen[Action{Kind: ActionMkdir, Path: dir}]
+}
+
+// exists reports whether path exists, taking the plan being made into account
+func (i *Installer) exists(path string) bool {
+	if i.planned(path) {
+		return true
+	}
+
+	if i.plan != nil {
+		for _, removed := range i.plan.removed {
+			if isWithin(removed, path) {
+				return false
+			}
+		}
+	}
+
+	_, err := os.Lstat(path)
+	return err == nil
+}
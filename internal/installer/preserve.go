@@ -0,0 +1,154 @@
+package installer
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// movePath moves src to dst, falling back to a faithful copy and removal
+// when a rename is not possible, e.g. across filesystems
+func movePath(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := copyPreserving(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
+
+// copyPreserving recursively copies src to dst without following symlinks,
+// keeping modes, timestamps, ownership (where permitted) and empty directories
+func copyPreserving(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		dest, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(dest, dst); err != nil {
+			return err
+		}
+		return lchown(dst, info)
+
+	case info.IsDir():
+		// Create writable first so children can be added, the real mode is set last
+		if err := os.Mkdir(dst, 0700); err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := copyPreserving(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+				return err
+			}
+		}
+
+	case info.Mode().IsRegular():
+		if err := copyContents(src, dst); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("cannot copy %s: unsupported file type %s", src, info.Mode().Type())
+	}
+
+	if err := lchown(dst, info); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+		return err
+	}
+
+	// A zero access time leaves it untouched
+	return os.Chtimes(dst, time.Time{}, info.ModTime())
+}
+
+func copyContents(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		return err
+	}
+
+	return dstFile.Close()
+}
+
+// checksumTree returns the SHA-256 of every regular file below root, keyed by
+// slash-separated path relative to root ("." when root is itself a file)
+func checksumTree(root string) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+
+	return sums, err
+}
+
+// verifyTree compares the files below root against recorded checksums and
+// describes every file that is missing or differs
+func verifyTree(root string, sums map[string]string) ([]string, error) {
+	var problems []string
+
+	for rel, want := range sums {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+
+		got, err := hashFile(path)
+		if os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("%s: missing", path))
+			continue
+		}
+		if err != nil {
+			return problems, err
+		}
+
+		if got != want {
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch", path))
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package installer
+
+import "os"
+
+// lchown is a no-op where files have no unix ownership
+func lchown(path string, info os.FileInfo) error {
+	return nil
+}
+
+func lchownIDs(path string, uid, gid int) error {
+	return nil
+}
+
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package installer
+
+import (
+	"os"
+	"syscall"
+)
+
+// lchown gives path the owner of info
+func lchown(path string, info os.FileInfo) error {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return nil
+	}
+
+	return lchownIDs(path, uid, gid)
+}
+
+// lchownIDs changes the owner of path without following symlinks. Only root
+// may hand files to other users, so permission errors are ignored and the
+// file stays ours.
+func lchownIDs(path string, uid, gid int) error {
+	if err := os.Lchown(path, uid, gid); err != nil && !os.IsPermission(err) {
+		return err
+	}
+
+	return nil
+}
+
+// fileOwner returns the user and group owning a file
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return int(stat.Uid), int(stat.Gid), true
+}
@@ -0,0 +1,101 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Stale returns what links holds that a repository no longer provides,
+// given its groups as scanned after an update and the names of the groups
+// that were installed: everything of installed groups that are gone,
+// entries outside their group's target, and links and copies whose source
+// no longer exists.
+func (i *Installer) Stale(links LinkSet, groups []DotfileGroup, installed []string) LinkSet {
+	stale := newLinkSet()
+
+	scanned := make(map[string]DotfileGroup)
+	for _, group := range groups {
+		scanned[group.Name] = group
+	}
+
+	for _, name := range installed {
+		if _, ok := scanned[name]; !ok {
+			stale.Merge(links.Group(name))
+		}
+	}
+
+	drop := func(target string) {
+		if source, ok := links.Symlinks[target]; ok {
+			stale.Symlinks[target] = source
+		}
+		if hash, ok := links.Copies[target]; ok {
+			stale.Copies[target] = hash
+		}
+		if entry, ok := links.Entries[target]; ok {
+			stale.Entries[target] = entry
+		}
+	}
+
+	// A group whose target moved is linked at the new one
+	for target, entry := range links.Entries {
+		if group, ok := scanned[entry.Group]; ok && !isWithin(group.Target, target) {
+			drop(target)
+		}
+	}
+
+	for target, source := range links.Symlinks {
+		if !i.sourceExists(source) {
+			drop(target)
+		}
+	}
+
+	// Copies only know their source through their entry
+	for target := range links.Copies {
+		if entry, ok := links.Entries[target]; ok && entry.Source != "" && !i.sourceExists(entry.Source) {
+			drop(target)
+		}
+	}
+
+	// Directories made for stale entries go when nothing else is below them
+	for _, dir := range links.Dirs {
+		if i.holdsOnly(dir, links, stale) {
+			stale.Dirs = append(stale.Dirs, dir)
+		}
+	}
+
+	return stale
+}
+
+// holdsOnly reports whether dir holds entries of links and all of them are stale
+func (i *Installer) holdsOnly(dir string, links, stale LinkSet) bool {
+	found := false
+	for _, targets := range []map[string]string{links.Symlinks, links.Copies} {
+		for target := range targets {
+			if !isWithin(dir, target) {
+				continue
+			}
+			_, isStale := stale.Symlinks[target]
+			if _, ok := stale.Copies[target]; ok {
+				isStale = true
+			}
+			if !isStale {
+				return false
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+// sourceExists reports whether a link or copy source is still there. While
+// an update is previewed, sources in the cache are looked up in the preview.
+func (i *Installer) sourceExists(source string) bool {
+	if i.preview != nil && isWithin(i.preview.RepoPath, source) {
+		if rel, err := filepath.Rel(i.preview.RepoPath, source); err == nil {
+			source = filepath.Join(i.preview.Contents, rel)
+		}
+	}
+
+	_, err := os.Lstat(source)
+	return err == nil
+}
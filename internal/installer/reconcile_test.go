@@ -0,0 +1,86 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStale(t *testing.T) {
+	tests := []struct {
+		name    string
+		deleted []string          // Sources removed from the repository, relative to root
+		targets map[string]string // Groups scanned after the update and their targets, relative to root
+		want    linkPaths
+	}{
+		{
+			name:    "nothing changed",
+			targets: map[string]string{"nvim": "home/.config/nvim", "zsh": "home", "git": "home/.config/git"},
+		},
+		{
+			name:    "deleted source takes its directory along",
+			deleted: []string{"cache/dots/config/nvim/lua/plugins.lua"},
+			targets: map[string]string{"nvim": "home/.config/nvim", "zsh": "home", "git": "home/.config/git"},
+			want: linkPaths{
+				Symlinks: []string{"home/.config/nvim/lua/plugins.lua"},
+				Dirs:     []string{"home/.config/nvim/lua"},
+			},
+		},
+		{
+			name:    "deleted copy source",
+			deleted: []string{"cache/dots/config/git/config"},
+			targets: map[string]string{"nvim": "home/.config/nvim", "zsh": "home", "git": "home/.config/git"},
+			want: linkPaths{
+				Copies: []string{"home/.config/git/config"},
+				Dirs:   []string{"home/.config/git"},
+			},
+		},
+		{
+			name:    "installed group no longer in the repository",
+			deleted: []string{"cache/dots/zsh/.zshrc"},
+			targets: map[string]string{"nvim": "home/.config/nvim", "git": "home/.config/git"},
+			want: linkPaths{
+				Symlinks: []string{"home/.zshrc"},
+			},
+		},
+		{
+			name:    "group target moved",
+			targets: map[string]string{"nvim": "home/.config/neovim", "zsh": "home", "git": "home/.config/git"},
+			want: linkPaths{
+				Symlinks: []string{"home/.config/nvim/init.lua", "home/.config/nvim/lua/plugins.lua"},
+				Dirs:     []string{"home/.config/nvim", "home/.config/nvim/lua"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, _ := newTestInstaller(t)
+			root := t.TempDir()
+			links := sampleLinks(t, root)
+
+			for _, path := range tt.deleted {
+				if err := os.Remove(filepath.Join(root, path)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var groups []DotfileGroup
+			for name, target := range tt.targets {
+				groups = append(groups, DotfileGroup{Name: name, Target: filepath.Join(root, target)})
+			}
+
+			stale := i.Stale(links, groups, []string{"nvim", "zsh", "git"})
+			if got := pathsOf(t, root, stale); !got.equal(tt.want) {
+				t.Errorf("Stale() = %+v, want %+v", got, tt.want)
+			}
+			for target := range stale.Entries {
+				_, link := stale.Symlinks[target]
+				_, copied := stale.Copies[target]
+				if !link && !copied {
+					t.Errorf("Stale() has an entry for %s but neither its link nor copy", target)
+				}
+			}
+		})
+	}
+}
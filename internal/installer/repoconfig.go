@@ -0,0 +1,155 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// RepoConfigFile is the optional per-repository settings file at the repo root
+const RepoConfigFile = "godots.toml"
+
+// RepoSettings describes the contents of a repository's godots.toml
+type RepoSettings struct {
+	DisableDefaults bool                     `toml:"disable_defaults"`
+	Strategy        string                   `toml:"strategy"`
+	Mappings        []MappingSettings        `toml:"mapping"`
+	Groups          map[string]GroupSettings `toml:"groups"`
+}
+
+// MappingSettings declares an extra source directory and where its entries are linked
+type MappingSettings struct {
+	Source string `toml:"source"`
+	Target string `toml:"target"`
+}
+
+// GroupSettings overrides how a single group is installed
+type GroupSettings struct {
+	Target   string `toml:"target"`
+	Strategy string `toml:"strategy"`
+}
+
+// LoadRepoSettings reads godots.toml from the repository root.
+// A missing file yields empty settings, which keeps the default behavior.
+func LoadRepoSettings(repoPath string) (*RepoSettings, error) {
+	settings := &RepoSettings{}
+
+	path := filepath.Join(repoPath, RepoConfigFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return settings, nil
+	}
+
+	if _, err := toml.DecodeFile(path, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", RepoConfigFile, err)
+	}
+
+	return settings, nil
+}
+
+// GroupStrategy returns the strategy for a group. A per-group setting wins,
+// then the fallback chosen on the command line, then the repo-wide setting.
+func (s *RepoSettings) GroupStrategy(name string, fallback Strategy) (Strategy, error) {
+	if group, ok := s.Groups[name]; ok && group.Strategy != "" {
+		return ParseStrategy(group.Strategy)
+	}
+
+	if fallback != "" {
+		return fallback, nil
+	}
+
+	return ParseStrategy(s.Strategy)
+}
+
+// Mappings returns the source->target mappings used to scan a repository
+func (i *Installer) Mappings(settings *RepoSettings) ([]PathMapping, error) {
+	var mappings []PathMapping
+
+	if !settings.DisableDefaults {
+		mappings = append(mappings, i.defaultMappings()...)
+	}
+
+	for _, m := range settings.Mappings {
+		if m.Source == "" || m.Target == "" {
+			return nil, fmt.Errorf("%s: mapping needs both source and target", RepoConfigFile)
+		}
+
+		source := filepath.Clean(m.Source)
+		if filepath.IsAbs(source) || source == ".." || strings.HasPrefix(source, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s: mapping source %q must be inside the repository", RepoConfigFile, m.Source)
+		}
+
+		mappings = append(mappings, PathMapping{
+			SourceDir: source,
+			TargetDir: i.expandPath(m.Target),
+		})
+	}
+
+	return mappings, nil
+}
+
+// defaultMappings follows the XDG base directories. When the data or state
+// home is moved away from ~/.local, local/share and local/state get their
+// own mappings so their entries land in the right place.
+func (i *Installer) defaultMappings() []PathMapping {
+	local := filepath.Join(i.cfg.HomeDir, ".local")
+
+	mappings := []PathMapping{
+		{SourceDir: "config", TargetDir: i.cfg.ConfigHome},
+	}
+
+	var skip []string
+	for _, xdg := range []struct{ name, dir string }{
+		{"share", i.cfg.DataHome},
+		{"state", i.cfg.StateHome},
+	} {
+		if xdg.dir != filepath.Join(local, xdg.name) {
+			mappings = append(mappings, PathMapping{SourceDir: filepath.Join("local", xdg.name), TargetDir: xdg.dir})
+			skip = append(skip, xdg.name)
+		}
+	}
+
+	mappings = append(mappings,
+		PathMapping{SourceDir: "local", TargetDir: local, Skip: skip},
+		PathMapping{SourceDir: "home", TargetDir: i.cfg.HomeDir},
+	)
+
+	return mappings
+}
+
+// expandPath expands ~ and environment variables in a target path.
+// Relative results are taken relative to the home directory. The path is
+// expanded as seen from inside the configured root and placed under it, with
+// $HOME and the XDG variables naming the configured directories.
+func (i *Installer) expandPath(path string) string {
+	home := i.cfg.Unroot(i.cfg.HomeDir)
+	vars := map[string]string{
+		"HOME":            home,
+		"XDG_CONFIG_HOME": i.cfg.Unroot(i.cfg.ConfigHome),
+		"XDG_CACHE_HOME":  i.cfg.Unroot(i.cfg.CacheHome),
+		"XDG_DATA_HOME":   i.cfg.Unroot(i.cfg.DataHome),
+		"XDG_STATE_HOME":  i.cfg.Unroot(i.cfg.StateHome),
+	}
+
+	path = os.Expand(path, func(key string) string {
+		if value, ok := vars[key]; ok {
+			return value
+		}
+		return os.Getenv(key)
+	})
+
+	if path == "~" {
+		path = home
+	}
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(home, path[2:])
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(home, path)
+	}
+
+	return i.cfg.Reroot(filepath.Clean(path))
+}
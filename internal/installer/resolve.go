@@ -0,0 +1,108 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Resolution is how an install handles a conflicting path
+type Resolution string
+
+const (
+	ResolveSkip      Resolution = "skip"      // Keep the existing path and leave the group uninstalled
+	ResolveBackup    Resolution = "backup"    // Move the existing path into a backup, then install
+	ResolveOverwrite Resolution = "overwrite" // Delete the existing path, then install
+	ResolveAdopt     Resolution = "adopt"     // Move the existing path into the repository, then install
+)
+
+// ResolveConflicts applies the chosen resolution to each conflict. Conflicts
+// without a resolution are backed up on behalf of repo. It returns the backup
+// directory, or an empty string when nothing was backed up.
+func (i *Installer) ResolveConflicts(repo string, conflicts []Conflict, resolutions map[string]Resolution) (string, error) {
+	var backups []string
+
+	for _, conflict := range conflicts {
+		switch resolutions[conflict.Path] {
+		case ResolveSkip:
+			continue
+
+		case ResolveOverwrite:
+			if err := i.remove(conflict.Path); err != nil {
+				return "", fmt.Errorf("failed to remove %s: %w", conflict.Path, err)
+			}
+
+		case ResolveAdopt:
+			if err := i.Adopt(conflict.Path, conflict.Source); err != nil {
+				return "", err
+			}
+
+		default:
+			backups = append(backups, conflict.Path)
+		}
+	}
+
+	if len(backups) == 0 {
+		return "", nil
+	}
+
+	return i.Backup(repo, "install", backups)
+}
+
+// Adopt moves an existing path into the repository at source, replacing
+// the repository's version. The caller links it back afterwards.
+func (i *Installer) Adopt(path, source string) error {
+	if source == "" {
+		return fmt.Errorf("cannot adopt %s: no repository location", path)
+	}
+
+	if i.plan != nil {
+		i.act(ActionAdopt, path, source)
+		return nil
+	}
+
+	if err := i.remove(source); err != nil {
+		return fmt.Errorf("failed to adopt %s: %w", path, err)
+	}
+	if err := i.mkdirAll(filepath.Dir(source)); err != nil {
+		return fmt.Errorf("failed to adopt %s: %w", path, err)
+	}
+
+	if err := os.Rename(path, source); err != nil {
+		// Different filesystems, copy and remove instead
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to adopt %s: %w", path, err)
+		}
+		if info.IsDir() {
+			err = copyDir(path, source, newIgnore())
+		} else {
+			err = copyFilePreserveMode(path, source)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to adopt %s: %w", path, err)
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to adopt %s: %w", path, err)
+		}
+	}
+
+	return i.record(Step{Kind: StepMove, Path: path, Source: source})
+}
+
+// Diff shows a unified diff between the existing path and the repository version
+func (i *Installer) Diff(conflict Conflict) error {
+	cmd := exec.Command("git", "diff", "--no-index", "--", conflict.Path, conflict.Source)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// git diff exits with 1 when the inputs differ
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return fmt.Errorf("diff failed: %w", err)
+	}
+
+	return nil
+}
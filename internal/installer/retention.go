@@ -0,0 +1,131 @@
+package installer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PruneResult describes the backups a prune removed, or would remove
+type PruneResult struct {
+	Removed   []BackupIndex
+	Reclaimed int64 // Bytes freed
+}
+
+// PruneBackups removes backups the retention policy in config.toml does not
+// keep. With dryRun nothing is removed, but the result is the same.
+func (i *Installer) PruneBackups(dryRun bool) (PruneResult, error) {
+	var result PruneResult
+
+	policy := i.cfg.Settings.Backups
+	if !policy.Retains() {
+		return result, nil // No policy, keep everything
+	}
+
+	within, err := parseRetention(policy.KeepWithin)
+	if err != nil {
+		return result, fmt.Errorf("invalid backups.keep_within: %w", err)
+	}
+
+	backups, err := i.Backups()
+	if err != nil {
+		return result, err
+	}
+
+	// The newest backup of every repository is always kept
+	floor := max(policy.KeepPerRepo, 1)
+
+	keep := make(map[string]bool)
+	perRepo := make(map[string]int)
+	now := time.Now()
+
+	// Walk newest first so the counting rules keep the most recent backups
+	for n := len(backups) - 1; n >= 0; n-- {
+		backup := backups[n]
+		newer := len(backups) - 1 - n
+
+		if newer < policy.KeepLast {
+			keep[backup.ID] = true
+		}
+		if within > 0 && now.Sub(backup.CreatedAt) < within {
+			keep[backup.ID] = true
+		}
+		if perRepo[backup.Repo] < floor {
+			keep[backup.ID] = true
+		}
+		perRepo[backup.Repo]++
+	}
+
+	removed := make(map[string]bool)
+	for _, backup := range backups {
+		if keep[backup.ID] {
+			continue
+		}
+		removed[backup.ID] = true
+
+		dir := filepath.Join(i.cfg.BackupDir, backup.ID)
+		size, err := dirSize(dir)
+		if err != nil {
+			return result, err
+		}
+
+		if !dryRun {
+			if err := os.RemoveAll(dir); err != nil {
+				return result, fmt.Errorf("failed to remove backup %s: %w", backup.ID, err)
+			}
+		}
+
+		result.Removed = append(result.Removed, backup)
+		result.Reclaimed += size
+	}
+
+	// Contents only the removed backups referred to can go as well
+	freed, err := i.collectBlobs(removed, dryRun)
+	if err != nil {
+		return result, err
+	}
+	result.Reclaimed += freed
+
+	return result, nil
+}
+
+// parseRetention parses a Go duration, also accepting whole days ("30d")
+func parseRetention(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// dirSize adds up the size of everything below dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
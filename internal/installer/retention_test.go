@@ -0,0 +1,103 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/grainedlotus515/godotctl/internal/config"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"0d", 0, false},
+		{"720h", 720 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"d", 0, true},
+		{"1.5d", 0, true},
+		{"30", 0, true},
+		{"week", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRetention(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRetention(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	// Oldest first, as Backups lists them
+	now := time.Now()
+	backups := []BackupIndex{
+		{ID: "a1", Repo: "a", CreatedAt: now.Add(-10 * 24 * time.Hour)},
+		{ID: "b1", Repo: "b", CreatedAt: now.Add(-9 * 24 * time.Hour)},
+		{ID: "a2", Repo: "a", CreatedAt: now.Add(-5 * 24 * time.Hour)},
+		{ID: "a3", Repo: "a", CreatedAt: now.Add(-time.Hour)},
+	}
+
+	tests := []struct {
+		name    string
+		policy  config.BackupSettings
+		want    []string
+		wantErr bool
+	}{
+		{"no policy keeps everything", config.BackupSettings{}, nil, false},
+		{"newest of each repository survives keep_last", config.BackupSettings{KeepLast: 1}, []string{"a1", "a2"}, false},
+		{"keep_last counts across repositories", config.BackupSettings{KeepLast: 3}, []string{"a1"}, false},
+		{"keep_within in days", config.BackupSettings{KeepWithin: "7d"}, []string{"a1"}, false},
+		{"keep_within as a duration", config.BackupSettings{KeepWithin: "2h"}, []string{"a1", "a2"}, false},
+		{"keep_per_repo", config.BackupSettings{KeepPerRepo: 2}, []string{"a1"}, false},
+		{"rules add up", config.BackupSettings{KeepLast: 1, KeepWithin: "6d"}, []string{"a1"}, false},
+		{"invalid keep_within", config.BackupSettings{KeepWithin: "soon"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, _ := newTestInstaller(t)
+			i.cfg.Settings.Backups = tt.policy
+
+			for _, backup := range backups {
+				dir := filepath.Join(i.cfg.BackupDir, backup.ID)
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := i.writeBackupIndex(dir, backup); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// A dry run reports the same backups and leaves them in place
+			for _, dryRun := range []bool{true, false} {
+				result, err := i.PruneBackups(dryRun)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("PruneBackups(%v) error = %v, want error %v", dryRun, err, tt.wantErr)
+				}
+
+				var removed []string
+				for _, backup := range result.Removed {
+					removed = append(removed, backup.ID)
+				}
+				if !slices.Equal(removed, tt.want) {
+					t.Errorf("PruneBackups(%v) removed %v, want %v", dryRun, removed, tt.want)
+				}
+			}
+
+			for _, backup := range backups {
+				_, err := os.Stat(filepath.Join(i.cfg.BackupDir, backup.ID))
+				if gone := os.IsNotExist(err); gone != slices.Contains(tt.want, backup.ID) {
+					t.Errorf("backup %s removed = %v, want %v", backup.ID, gone, !gone)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Revision identifies the commit a cached repository has checked out
+type Revision struct {
+	Commit string    `toml:"commit" json:"commit"`
+	Branch string    `toml:"branch,omitempty" json:"branch,omitempty"` // Empty for a detached HEAD
+	Date   time.Time `toml:"date" json:"date"`                         // Commit date
+}
+
+// Short returns the abbreviated commit hash
+func (r Revision) Short() string {
+	if len(r.Commit) > 7 {
+		return r.Commit[:7]
+	}
+	return r.Commit
+}
+
+// HeadCommit returns the commit checked out in a cached repository, or an
+// empty string for caches that are not git repositories
+func HeadCommit(repoPath string) string {
+	if repoPath == "" {
+		return ""
+	}
+
+	// Without this check git would report a repository further up
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err != nil {
+		return ""
+	}
+
+	commit, err := gitOutput(repoPath, "rev-parse", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return commit
+}
+
+// ReadRevision returns the revision checked out in a cached repository, or
+// nil when the cache is not a git repository
+func ReadRevision(repoPath string) *Revision {
+	commit := HeadCommit(repoPath)
+	if commit == "" {
+		return nil
+	}
+
+	revision := &Revision{Commit: commit}
+
+	if branch, err := gitOutput(repoPath, "symbolic-ref", "--quiet", "--short", "HEAD"); err == nil {
+		revision.Branch = branch
+	}
+	if date, err := gitOutput(repoPath, "log", "-1", "--format=%cI", commit); err == nil {
+		revision.Date, _ = time.Parse(time.RFC3339, date)
+	}
+
+	return revision
+}
+
+// ShortLog lists the commits after from up to and including to, newest
+// first, one line each
+func ShortLog(repoPath, from, to string) ([]string, error) {
+	out, err := gitOutput(repoPath, "log", "--oneline", "--no-decorate", from+".."+to)
+	if err != nil || out == "" {
+		return nil, err
+	}
+
+	return strings.Split(out, "\n"), nil
+}
+
+// Checkout moves a cached repository to commit, fetching when the cache does
+// not have it yet. A branch is reset to commit so later updates pull into it.
+func Checkout(repoPath, commit, branch string) error {
+	if _, err := gitOutput(repoPath, "cat-file", "-e", commit+"^{commit}"); err != nil {
+		if _, err := gitOutput(repoPath, "fetch", "--quiet", "origin"); err != nil {
+			return fmt.Errorf("commit %s is not in %s and fetching failed: %w", commit, repoPath, err)
+		}
+	}
+
+	args := []string{"-C", repoPath, "checkout", "--quiet", "--detach", commit}
+	if branch != "" {
+		args = []string{"-C", repoPath, "checkout", "--quiet", "-B", branch, commit}
+	}
+
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git checkout failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	// Let update pull the branch, when the remote has it
+	if branch != "" {
+		gitOutput(repoPath, "branch", "--quiet", "--set-upstream-to=origin/"+branch, branch)
+	}
+
+	return nil
+}
@@ -1,29 +1,46 @@
 package installer
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 )
 
 type DotfileGroup struct {
-	Name   string
-	Source string
-	Target string
-	Files  []string
+	Name     string
+	Source   string
+	Target   string
+	Files    []string
+	Strategy Strategy
+	Variant  string // Alternate file selected for this machine, if any
+	Template bool   // Group contains .tmpl files and is linked from its rendered output
+
+	ignore *Ignore // Ignore rules in effect for the group's source
 }
 
 type PathMapping struct {
 	SourceDir string
 	TargetDir string
+	Skip      []string // Entries handled by a more specific mapping
 }
 
 func (i *Installer) Scan(repoPath string) ([]DotfileGroup, error) {
 	var groups []DotfileGroup
 
-	mappings := []PathMapping{
-		{SourceDir: "config", TargetDir: filepath.Join(i.cfg.HomeDir, ".config")},
-		{SourceDir: "local", TargetDir: filepath.Join(i.cfg.HomeDir, ".local")},
-		{SourceDir: "home", TargetDir: i.cfg.HomeDir},
+	settings, err := LoadRepoSettings(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := i.Mappings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	rootIgnore, err := linkIgnore().Load(repoPath)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, mapping := range mappings {
@@ -34,26 +51,44 @@ func (i *Installer) Scan(repoPath string) ([]DotfileGroup, error) {
 			continue
 		}
 
-		// Scan for subdirectories/files
-		entries, err := os.ReadDir(sourcePath)
+		ignore, err := rootIgnore.LoadPath(repoPath, sourcePath)
+		if err != nil {
+			return nil, err
+		}
+
+		// Scan for subdirectories/files, resolving alternates
+		entries, err := i.readSourceDir(sourcePath, ignore)
 		if err != nil {
 			continue
 		}
 
 		for _, entry := range entries {
-			// Skip hooks directory
-			if entry.Name() == "hooks" {
+			// Skip hooks directory and entries claimed by other mappings
+			if entry.Name == "hooks" || slices.Contains(mapping.Skip, entry.Name) {
 				continue
 			}
 
-			source := filepath.Join(sourcePath, entry.Name())
-			target := filepath.Join(mapping.TargetDir, entry.Name())
+			target := filepath.Join(mapping.TargetDir, entry.Name)
+
+			// Apply per-group overrides from godots.toml
+			if override, ok := settings.Groups[entry.Name]; ok && override.Target != "" {
+				target = i.expandPath(override.Target)
+			}
+
+			strategy, err := settings.GroupStrategy(entry.Name, i.strategy)
+			if err != nil {
+				return nil, fmt.Errorf("group %s: %w", entry.Name, err)
+			}
 
 			group := DotfileGroup{
-				Name:   entry.Name(),
-				Source: source,
-				Target: target,
-				Files:  []string{entry.Name()},
+				Name:     entry.Name,
+				Source:   entry.Path,
+				Target:   target,
+				Files:    []string{filepath.Base(entry.Path)},
+				Strategy: strategy,
+				Variant:  entry.Variant,
+				Template: entry.Template || (entry.IsDir && i.containsTemplates(entry.Path, ignore)),
+				ignore:   ignore,
 			}
 
 			groups = append(groups, group)
@@ -62,3 +97,12 @@ func (i *Installer) Scan(repoPath string) ([]DotfileGroup, error) {
 
 	return groups, nil
 }
+
+// ignoreRules returns the rules Scan found for the group, or the built-ins
+// for groups that were constructed elsewhere
+func (g DotfileGroup) ignoreRules() *Ignore {
+	if g.ignore == nil {
+		return linkIgnore()
+	}
+	return g.ignore
+}
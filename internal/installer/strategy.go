@@ -0,0 +1,24 @@
+package installer
+
+import "fmt"
+
+// Strategy controls how a group is placed into the target directory
+type Strategy string
+
+const (
+	StrategySymlink Strategy = "symlink" // Link the whole group as one symlink
+	StrategyTree    Strategy = "tree"    // Mirror directories and link individual files
+	StrategyCopy    Strategy = "copy"    // Mirror directories and copy individual files
+)
+
+// ParseStrategy validates a strategy name, treating an empty name as the default
+func ParseStrategy(name string) (Strategy, error) {
+	switch Strategy(name) {
+	case "":
+		return StrategySymlink, nil
+	case StrategySymlink, StrategyTree, StrategyCopy:
+		return Strategy(name), nil
+	}
+
+	return "", fmt.Errorf("unknown strategy %q", name)
+}
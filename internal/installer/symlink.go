@@ -4,31 +4,94 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 )
 
-func (i *Installer) CreateSymlinks(groups []DotfileGroup) (map[string]string, error) {
-	symlinks := make(map[string]string)
+// LinkSet records everything CreateSymlinks placed on disk so it can be undone
+type LinkSet struct {
+	Symlinks   map[string]string    // link target -> source
+	Dirs       []string             // real directories created by tree linking
+	Unfolded   map[string]string    // directory -> source it was folded onto before unfolding
+	Strategies map[string]Strategy  // group name -> strategy used
+	Rendered   []string             // rendered template output trees
+	Copies     map[string]string    // copied file -> SHA-256 of the contents written
+	Entries    map[string]LinkEntry // link or copied file -> how it was placed
+	Changed    int                  // entries actually written, as opposed to already in place
+}
+
+func newLinkSet() LinkSet {
+	return LinkSet{
+		Symlinks:   make(map[string]string),
+		Unfolded:   make(map[string]string),
+		Strategies: make(map[string]Strategy),
+		Copies:     make(map[string]string),
+		Entries:    make(map[string]LinkEntry),
+	}
+}
+
+func (i *Installer) CreateSymlinks(groups []DotfileGroup) (LinkSet, error) {
+	links := newLinkSet()
 
 	for _, group := range groups {
-		// Ensure parent directory exists
-		parent := filepath.Dir(group.Target)
-		if err := os.MkdirAll(parent, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create parent dir %s: %w", parent, err)
+		group, err := i.prepareSource(group, &links)
+		if err != nil {
+			return links, err
 		}
 
-		// Create symlink
-		if err := os.Symlink(group.Source, group.Target); err != nil {
-			return nil, fmt.Errorf("failed to create symlink %s -> %s: %w", group.Target, group.Source, err)
+		switch group.Strategy {
+		case StrategyTree:
+			if err := i.linkTree(group.Source, group.Target, group.ignoreRules(), &links); err != nil {
+				return links, err
+			}
+
+		case StrategyCopy:
+			if err := i.copyTree(group.Source, group.Target, group.ignoreRules(), &links); err != nil {
+				return links, err
+			}
+
+		default:
+			// Ensure parent directory exists
+			parent := filepath.Dir(group.Target)
+			if err := i.mkdirAll(parent); err != nil {
+				return links, fmt.Errorf("failed to create parent dir %s: %w", parent, err)
+			}
+
+			// Create symlink, keeping one that is already correct
+			if err := i.placeLink(group.Source, group.Target, &links); err != nil {
+				return links, fmt.Errorf("failed to create symlink %s -> %s: %w", group.Target, group.Source, err)
+			}
 		}
 
-		symlinks[group.Target] = group.Source
+		links.Strategies[group.Name] = group.Strategy
+		i.describe(group, &links)
 	}
 
-	return symlinks, nil
+	return links, nil
 }
 
-func (i *Installer) RemoveSymlinks(symlinks map[string]string) error {
-	for target := range symlinks {
+// prepareSource points templated groups at their freshly rendered output
+func (i *Installer) prepareSource(group DotfileGroup, links *LinkSet) (DotfileGroup, error) {
+	if !group.Template {
+		return group, nil
+	}
+
+	output, err := i.Render(group)
+	if err != nil {
+		return group, err
+	}
+
+	group.Source = output
+	if !slices.Contains(links.Rendered, output) {
+		links.Rendered = append(links.Rendered, output)
+	}
+
+	return group, nil
+}
+
+func (i *Installer) RemoveSymlinks(links LinkSet) error {
+	for target, source := range links.Symlinks {
 		// Verify it's actually a symlink before removing
 		info, err := os.Lstat(target)
 		if err != nil {
@@ -36,11 +99,220 @@ func (i *Installer) RemoveSymlinks(symlinks map[string]string) error {
 		}
 
 		if info.Mode()&os.ModeSymlink != 0 {
-			if err := os.Remove(target); err != nil {
+			if err := i.remove(target); err != nil {
 				return fmt.Errorf("failed to remove symlink %s: %w", target, err)
 			}
+		} else if info.IsDir() {
+			// Another install unfolded our link, remove the per-entry links it left behind
+			if err := i.removeUnfoldedLinks(target, source); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Copies are only removed while they still hold what godots wrote
+	for target, hash := range links.Copies {
+		if err := i.removeCopy(target, hash); err != nil {
+			return err
+		}
+	}
+
+	// Fold directories back onto their original source where nothing else remains
+	for dir, source := range links.Unfolded {
+		if err := i.refold(dir, source); err != nil {
+			return err
+		}
+	}
+
+	// Remove created directories deepest first, keeping any that are still in use
+	dirs := append([]string(nil), links.Dirs...)
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if _, unfolded := links.Unfolded[dir]; unfolded {
+			continue
+		}
+		if err := i.removeDir(dir); err != nil {
+			return err
+		}
+	}
+
+	// Rendered outputs are only reachable through the links just removed
+	for _, output := range links.Rendered {
+		if err := i.remove(output); err != nil {
+			return fmt.Errorf("failed to remove rendered output %s: %w", output, err)
 		}
 	}
 
 	return nil
 }
+
+// symlink links target to source, addressing source as seen from inside the configured root
+func (i *Installer) symlink(source, target string) error {
+	if i.plan != nil {
+		i.act(ActionLink, target, source)
+		return nil
+	}
+
+	if err := os.Symlink(i.cfg.Unroot(source), target); err != nil {
+		return err
+	}
+
+	return i.created(target)
+}
+
+// readlink returns the destination of a link as a path on this system
+func (i *Installer) readlink(path string) (string, error) {
+	dest, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+
+	return i.cfg.Reroot(dest), nil
+}
+
+// Relink replaces whatever is recorded at group.Target with a fresh link of group
+func (i *Installer) Relink(group DotfileGroup, links *LinkSet) error {
+	old := links.Subset(group.Target)
+	if err := i.RemoveSymlinks(old); err != nil {
+		return err
+	}
+	links.Remove(old)
+
+	created, err := i.CreateSymlinks([]DotfileGroup{group})
+	links.Merge(created)
+	return err
+}
+
+// Subset returns the entries placed at or below target
+func (l LinkSet) Subset(target string) LinkSet {
+	subset := newLinkSet()
+
+	for link, source := range l.Symlinks {
+		if isWithin(target, link) {
+			subset.Symlinks[link] = source
+		}
+	}
+	for _, dir := range l.Dirs {
+		if isWithin(target, dir) {
+			subset.Dirs = append(subset.Dirs, dir)
+		}
+	}
+	for dir, source := range l.Unfolded {
+		if isWithin(target, dir) {
+			subset.Unfolded[dir] = source
+		}
+	}
+	for file, hash := range l.Copies {
+		if isWithin(target, file) {
+			subset.Copies[file] = hash
+		}
+	}
+	for path, entry := range l.Entries {
+		if isWithin(target, path) {
+			subset.Entries[path] = entry
+		}
+	}
+
+	return subset
+}
+
+// Clone returns a copy of l that can be changed independently
+func (l LinkSet) Clone() LinkSet {
+	clone := newLinkSet()
+	clone.Merge(l)
+	return clone
+}
+
+// Merge adds every entry of other to l
+func (l *LinkSet) Merge(other LinkSet) {
+	if l.Symlinks == nil {
+		l.Symlinks = make(map[string]string)
+	}
+	if l.Unfolded == nil {
+		l.Unfolded = make(map[string]string)
+	}
+	if l.Strategies == nil {
+		l.Strategies = make(map[string]Strategy)
+	}
+	if l.Copies == nil {
+		l.Copies = make(map[string]string)
+	}
+	if l.Entries == nil {
+		l.Entries = make(map[string]LinkEntry)
+	}
+
+	for link, source := range other.Symlinks {
+		l.Symlinks[link] = source
+	}
+	for dir, source := range other.Unfolded {
+		l.Unfolded[dir] = source
+	}
+	for group, strategy := range other.Strategies {
+		l.Strategies[group] = strategy
+	}
+	for file, hash := range other.Copies {
+		l.Copies[file] = hash
+	}
+	for path, entry := range other.Entries {
+		// Placing the same source again keeps when and over what it was first placed
+		if old, ok := l.Entries[path]; ok && old.Source == entry.Source {
+			entry.CreatedAt = old.CreatedAt
+			if entry.Backup == "" {
+				entry.Backup = old.Backup
+			}
+		}
+		l.Entries[path] = entry
+	}
+	for _, dir := range other.Dirs {
+		if !slices.Contains(l.Dirs, dir) {
+			l.Dirs = append(l.Dirs, dir)
+		}
+	}
+	l.Changed += other.Changed
+
+	for _, output := range other.Rendered {
+		if !slices.Contains(l.Rendered, output) {
+			l.Rendered = append(l.Rendered, output)
+		}
+	}
+}
+
+// Remove drops every entry of other from l
+func (l *LinkSet) Remove(other LinkSet) {
+	for link := range other.Symlinks {
+		delete(l.Symlinks, link)
+	}
+	for dir := range other.Unfolded {
+		delete(l.Unfolded, dir)
+	}
+	for file := range other.Copies {
+		delete(l.Copies, file)
+	}
+	for path := range other.Entries {
+		delete(l.Entries, path)
+	}
+	for group := range other.Strategies {
+		delete(l.Strategies, group)
+	}
+	l.Rendered = slices.DeleteFunc(l.Rendered, func(output string) bool {
+		return slices.Contains(other.Rendered, output)
+	})
+
+	dirs := l.Dirs[:0]
+	for _, dir := range l.Dirs {
+		if !slices.Contains(other.Dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	l.Dirs = dirs
+}
+
+// isWithin reports whether path is root or lies below it
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
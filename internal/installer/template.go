@@ -0,0 +1,201 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+)
+
+// TemplateSuffix marks files that are rendered before being installed
+const TemplateSuffix = ".tmpl"
+
+// TemplateData is passed to every .tmpl file. Machine facts are available
+// directly (e.g. {{ .Host }}) and user variables under .Vars.
+type TemplateData struct {
+	Facts
+	Home string
+	Vars map[string]any
+}
+
+// HasClass reports whether the machine belongs to a class, for use in templates
+func (d TemplateData) HasClass(class string) bool {
+	return slices.Contains(d.Classes, class)
+}
+
+// Render writes the group's rendered output tree and returns its path.
+// Templates are executed, every other file is linked back to the repository,
+// so the output can be installed with any strategy.
+func (i *Installer) Render(group DotfileGroup) (string, error) {
+	data, err := i.templateData()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := i.renderPath(group)
+	if err != nil {
+		return "", err
+	}
+
+	// Plans link against the previous output, or against the templates and
+	// are pointed at the output afterwards
+	if i.plan != nil {
+		i.act(ActionRender, output, group.Source)
+		if _, err := os.Lstat(output); err == nil {
+			return output, nil
+		}
+		i.plan.renders[group.Source] = output
+		return group.Source, nil
+	}
+
+	// Start from scratch so files removed from the repo disappear
+	if err := i.remove(output); err != nil {
+		return "", err
+	}
+	if err := i.mkdirAll(filepath.Dir(output)); err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(group.Source)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		if err := i.created(output); err != nil {
+			return "", err
+		}
+		if err := renderFile(group.Source, output, data); err != nil {
+			return "", err
+		}
+		return output, nil
+	}
+
+	if err := i.renderTree(group.Source, output, group.ignoreRules(), data); err != nil {
+		return "", err
+	}
+
+	return output, nil
+}
+
+// renderPath mirrors the group's location in the cache under the render directory
+func (i *Installer) renderPath(group DotfileGroup) (string, error) {
+	rel, err := filepath.Rel(i.cfg.CacheDir, filepath.Dir(i.cachePath(group.Source)))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(i.cfg.RenderDir, rel, group.Name), nil
+}
+
+func (i *Installer) renderTree(source, output string, ignore *Ignore, data TemplateData) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(output, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := i.created(output); err != nil {
+		return err
+	}
+
+	ignore, err = ignore.Load(source)
+	if err != nil {
+		return err
+	}
+
+	entries, err := i.readSourceDir(source, ignore)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		dst := filepath.Join(output, entry.Name)
+
+		switch {
+		case entry.IsDir:
+			err = i.renderTree(entry.Path, dst, ignore, data)
+		case entry.Template:
+			if err = i.created(dst); err == nil {
+				err = renderFile(entry.Path, dst, data)
+			}
+		default:
+			err = i.symlink(entry.Path, dst)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func renderFile(source, output string, data TemplateData) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(filepath.Base(source)).Option("missingkey=error").ParseFiles(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse template %s: %w", source, err)
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := tmpl.Execute(f, data); err != nil {
+		return fmt.Errorf("failed to render template %s: %w", source, err)
+	}
+
+	return nil
+}
+
+// containsTemplates reports whether any non-ignored file below dir is a template
+func (i *Installer) containsTemplates(dir string, ignore *Ignore) bool {
+	ignore, err := ignore.Load(dir)
+	if err != nil {
+		return false
+	}
+
+	entries, err := i.readSourceDir(dir, ignore)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		if entry.Template || (entry.IsDir && i.containsTemplates(entry.Path, ignore)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// templateData combines machine facts with the variables from the user's data file
+func (i *Installer) templateData() (TemplateData, error) {
+	data := TemplateData{
+		Facts: i.Facts(),
+		Home:  i.cfg.Unroot(i.cfg.HomeDir),
+		Vars:  make(map[string]any),
+	}
+
+	if _, err := os.Stat(i.cfg.DataFile); os.IsNotExist(err) {
+		return data, nil
+	}
+
+	if _, err := toml.DecodeFile(i.cfg.DataFile, &data.Vars); err != nil {
+		return data, fmt.Errorf("failed to parse %s: %w", i.cfg.DataFile, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,233 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// linkTree mirrors the source tree under target, creating real directories
+// and linking individual files, like GNU Stow with folding disabled for the
+// group root.
+func (i *Installer) linkTree(source, target string, ignore *Ignore, links *LinkSet) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return i.linkFile(source, target, links)
+	}
+
+	if err := i.ensureTreeDir(target, links); err != nil {
+		return err
+	}
+
+	ignore, err = ignore.Load(source)
+	if err != nil {
+		return err
+	}
+
+	entries, err := i.readSourceDir(source, ignore)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := i.linkTree(entry.Path, filepath.Join(target, entry.Name), ignore, links); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// linkFile creates a single file symlink, making any missing parents first
+func (i *Installer) linkFile(source, target string, links *LinkSet) error {
+	if err := i.ensureTreeDir(filepath.Dir(target), links); err != nil {
+		return err
+	}
+
+	if err := i.placeLink(source, target, links); err != nil {
+		return fmt.Errorf("failed to create symlink %s -> %s: %w", target, source, err)
+	}
+
+	return nil
+}
+
+// ensureTreeDir makes sure dir is a real directory, creating missing
+// ancestors and unfolding directory symlinks that point into the cache.
+func (i *Installer) ensureTreeDir(dir string, links *LinkSet) error {
+	if i.planned(dir) {
+		return nil
+	}
+
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		if err := i.ensureTreeDir(filepath.Dir(dir), links); err != nil {
+			return err
+		}
+		if err := i.mkdir(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+		links.Dirs = append(links.Dirs, dir)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return nil
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		source, err := i.readlink(dir)
+		if err == nil && i.isManaged(source) {
+			return i.unfold(dir, source, links)
+		}
+
+		// Foreign symlinks to directories are followed as-is
+		if target, err := os.Stat(dir); err == nil && target.IsDir() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot create directory %s: path exists and is not a directory", dir)
+}
+
+// unfold replaces a folded directory symlink with a real directory holding
+// one symlink per entry of the directory it pointed to.
+func (i *Installer) unfold(dir, source string, links *LinkSet) error {
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return fmt.Errorf("failed to unfold %s: %w", dir, err)
+	}
+
+	if err := i.remove(dir); err != nil {
+		return fmt.Errorf("failed to unfold %s: %w", dir, err)
+	}
+	if err := i.mkdir(dir, 0755); err != nil {
+		return fmt.Errorf("failed to unfold %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		if err := i.symlink(filepath.Join(source, entry.Name()), filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to unfold %s: %w", dir, err)
+		}
+	}
+
+	// A folded link we created ourselves is now owned per entry
+	if _, ours := links.Symlinks[dir]; ours {
+		delete(links.Symlinks, dir)
+		folded, described := links.Entries[dir]
+		delete(links.Entries, dir)
+
+		for _, entry := range entries {
+			link, dest := filepath.Join(dir, entry.Name()), filepath.Join(source, entry.Name())
+			links.Symlinks[link] = dest
+			if described {
+				links.Entries[link] = DescribeLink(folded.Group, folded.Strategy, dest, link, folded.Commit)
+			}
+		}
+		links.Dirs = append(links.Dirs, dir)
+		return nil
+	}
+
+	links.Unfolded[dir] = source
+	links.Dirs = append(links.Dirs, dir)
+	return nil
+}
+
+// refold turns an unfolded directory back into a single symlink once every
+// remaining entry is a link into the original source.
+func (i *Installer) refold(dir, source string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil // Already gone
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		dest, err := i.readlink(path)
+		if err != nil || dest != filepath.Join(source, entry.Name()) {
+			return nil // Something else lives here now, keep the directory
+		}
+	}
+
+	if err := i.removeUnfoldedLinks(dir, source); err != nil {
+		return err
+	}
+	if i.exists(dir) {
+		return nil // Directory could not be removed, leave it unfolded
+	}
+
+	if err := i.symlink(source, dir); err != nil {
+		return fmt.Errorf("failed to refold %s: %w", dir, err)
+	}
+
+	return nil
+}
+
+// removeUnfoldedLinks removes the per-entry links pointing into source and
+// the directory itself when nothing else is left in it.
+func (i *Installer) removeUnfoldedLinks(dir, source string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		dest, err := i.readlink(path)
+		if err != nil || dest != filepath.Join(source, entry.Name()) {
+			continue
+		}
+		if err := i.remove(path); err != nil {
+			return fmt.Errorf("failed to remove symlink %s: %w", path, err)
+		}
+	}
+
+	return i.removeDir(dir)
+}
+
+// isManaged reports whether path lives inside the godots cache or render directory
+func (i *Installer) isManaged(path string) bool {
+	_, ok := i.managedPath(path)
+	return ok
+}
+
+// repoOf returns the name of the repository a managed path belongs to
+func (i *Installer) repoOf(path string) string {
+	rel, ok := i.managedPath(path)
+	if !ok || rel == "." {
+		return ""
+	}
+
+	return strings.SplitN(rel, string(filepath.Separator), 2)[0]
+}
+
+// managedPath returns path relative to the cache or render directory it lives in
+func (i *Installer) managedPath(path string) (string, bool) {
+	path = i.cachePath(path)
+
+	// Links made before the cache moved still go through its old location
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		resolved = path
+	}
+
+	for _, root := range []string{i.cfg.CacheDir, i.cfg.RenderDir} {
+		if isWithin(root, path) {
+			rel, err := filepath.Rel(root, path)
+			return rel, err == nil
+		}
+		if realRoot, err := filepath.EvalSymlinks(root); err == nil && isWithin(realRoot, resolved) {
+			rel, err := filepath.Rel(realRoot, resolved)
+			return rel, err == nil
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,162 @@
+package manifest
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/grainedlotus515/godotctl/internal/installer"
+)
+
+// LockfileVersion is the lockfile format this godotctl reads and writes
+const LockfileVersion = 1
+
+// Lockfile pins installed repositories so another machine can be set up the
+// same way. Targets under the home directory are written as ~/..., sources
+// relative to their repository.
+type Lockfile struct {
+	Version int          `toml:"version"`
+	Repos   []LockedRepo `toml:"repos"`
+}
+
+type LockedRepo struct {
+	Name       string               `toml:"name"`
+	URL        string               `toml:"url"`
+	SourceType installer.SourceType `toml:"source_type"`
+	Commit     string               `toml:"commit,omitempty"` // Empty for plain directories
+	Branch     string               `toml:"branch,omitempty"`
+	Groups     []LockedGroup        `toml:"groups"`
+	Links      map[string]string    `toml:"links,omitempty"` // Target -> source in the repository
+}
+
+type LockedGroup struct {
+	Name     string             `toml:"name"`
+	Strategy installer.Strategy `toml:"strategy"`
+	Target   string             `toml:"target"`
+}
+
+// LockRepo pins an installed repository. groups are its installed groups
+// as scanned from the cache; renderDir is where its templates are rendered.
+func LockRepo(name string, repo RepoConfig, groups []installer.DotfileGroup, home, renderDir string) LockedRepo {
+	locked := LockedRepo{
+		Name:       name,
+		URL:        repo.URL,
+		SourceType: repo.SourceType,
+		Links:      make(map[string]string),
+	}
+	if repo.Revision != nil {
+		locked.Commit = repo.Revision.Commit
+		locked.Branch = repo.Revision.Branch
+	}
+
+	for _, group := range groups {
+		strategy, ok := repo.Strategies[group.Name]
+		if !ok {
+			strategy = group.Strategy
+		}
+		locked.Groups = append(locked.Groups, LockedGroup{
+			Name:     group.Name,
+			Strategy: strategy,
+			Target:   HomeRelative(home, group.Target),
+		})
+	}
+
+	// Rendered templates mirror the repository layout
+	rendered := filepath.Join(renderDir, name)
+	relative := func(source string) string {
+		for _, root := range []string{repo.CachedAt, rendered} {
+			if rel, err := filepath.Rel(root, source); err == nil && !outside(rel) {
+				return filepath.ToSlash(rel)
+			}
+		}
+		return source
+	}
+
+	for target, source := range repo.Symlinks {
+		locked.Links[HomeRelative(home, target)] = relative(source)
+	}
+	for target := range repo.Copies {
+		if entry, ok := repo.Entries[target]; ok {
+			locked.Links[HomeRelative(home, target)] = relative(entry.Source)
+		}
+	}
+
+	return locked
+}
+
+// Missing returns the links of the lockfile that repo does not have
+func (r LockedRepo) Missing(home string, repo RepoConfig) []string {
+	var missing []string
+	for target := range r.Links {
+		path := ExpandHome(home, target)
+		if _, ok := repo.Symlinks[path]; ok {
+			continue
+		}
+		if _, ok := repo.Copies[path]; ok {
+			continue
+		}
+		missing = append(missing, target)
+	}
+
+	slices.Sort(missing)
+	return missing
+}
+
+// Encode writes the lockfile as TOML
+func (l Lockfile) Encode(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(l)
+}
+
+// Save replaces the lockfile at path atomically
+func (l Lockfile) Save(path string) error {
+	return writeFile(path, l.Encode)
+}
+
+// ReadLockfile reads a lockfile written by export-lock
+func ReadLockfile(path string) (Lockfile, error) {
+	var lock Lockfile
+	if _, err := toml.DecodeFile(path, &lock); err != nil {
+		return lock, fmt.Errorf("failed to read lockfile: %w", err)
+	}
+
+	if lock.Version == 0 {
+		return lock, fmt.Errorf("%s is not a godotctl lockfile", path)
+	}
+	if lock.Version > LockfileVersion {
+		return lock, fmt.Errorf("%s was written by a newer godotctl (format %d, this one knows %d)", path, lock.Version, LockfileVersion)
+	}
+
+	return lock, nil
+}
+
+// outside reports whether a relative path climbs out of its base, while
+// names such as ..foo stay inside
+func outside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// HomeRelative writes path as ~/... when it is inside home
+func HomeRelative(home, path string) string {
+	rel, err := filepath.Rel(home, path)
+	if err != nil || outside(rel) {
+		return path
+	}
+	if rel == "." {
+		return "~"
+	}
+	return "~/" + filepath.ToSlash(rel)
+}
+
+// ExpandHome turns a path written by HomeRelative back into an absolute one
+func ExpandHome(home, path string) string {
+	if path == "~" {
+		return home
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(home, filepath.FromSlash(rest))
+	}
+	return path
+}
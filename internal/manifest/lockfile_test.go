@@ -0,0 +1,29 @@
+package manifest
+
+import "testing"
+
+func TestHomeRelative(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/home/u", "~"},
+		{"/home/u/.config/nvim", "~/.config/nvim"},
+		{"/home/u/..foo", "~/..foo"},
+		{"/home/u/...", "~/..."},
+		{"/home", "/home"},
+		{"/home/other/.zshrc", "/home/other/.zshrc"},
+		{"/home/u2/.zshrc", "/home/u2/.zshrc"},
+		{"/etc/pacman.conf", "/etc/pacman.conf"},
+	}
+
+	for _, tt := range tests {
+		got := HomeRelative("/home/u", tt.path)
+		if got != tt.want {
+			t.Errorf("HomeRelative(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+		if back := ExpandHome("/home/u", got); back != tt.path {
+			t.Errorf("ExpandHome(%q) = %q, want %q", got, back, tt.path)
+		}
+	}
+}
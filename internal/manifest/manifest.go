@@ -1,100 +1,284 @@
 package manifest
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/grainedlotus515/godotctl/internal/config"
 	"github.com/grainedlotus515/godotctl/internal/installer"
 )
 
+// BackupSuffix names the previous manifest, kept by Save as a fallback for Load
+const BackupSuffix = ".bak"
+
+// Manager reads and writes the manifest. Paths are stored as seen from
+// inside the configured root, so a manifest written into a chroot or image
+// stays valid when godotctl later runs there.
 type Manager struct {
 	path string
+	cfg  *config.Config
 }
 
-func New(path string) *Manager {
-	return &Manager{path: path}
+func New(cfg *config.Config) *Manager {
+	return &Manager{path: cfg.ManifestPath, cfg: cfg}
 }
 
-func (m *Manager) Load() (map[string]RepoConfig, error) {
-	var manifest Manifest
+// warn reports problems Load recovered from
+var warn = func(string) {}
+
+// SetWarn sets where Load reports problems it recovered from
+func SetWarn(fn func(string)) {
+	warn = fn
+}
 
+// Load reads the manifest, falling back to the previous one when it is
+// damaged. Manifests of an older schema are migrated in memory and written
+// by the next Save; newer ones are read as far as this version understands
+// them, but Save refuses to overwrite them.
+func (m *Manager) Load() (map[string]RepoConfig, error) {
 	if _, err := os.Stat(m.path); os.IsNotExist(err) {
 		return make(map[string]RepoConfig), nil
 	}
 
-	if _, err := toml.DecodeFile(m.path, &manifest); err != nil {
+	repos, err := m.load(m.path)
+	if err == nil {
+		return repos, nil
+	}
+
+	backup := m.path + BackupSuffix
+	if _, statErr := os.Stat(backup); statErr != nil {
+		return nil, err
+	}
+
+	repos, backupErr := m.load(backup)
+	if backupErr != nil {
+		return nil, err
+	}
+
+	warn(fmt.Sprintf("%s is damaged (%v), using %s", m.path, err, backup))
+	return repos, nil
+}
+
+func (m *Manager) load(path string) (map[string]RepoConfig, error) {
+	var manifest Manifest
+
+	var raw map[string]any
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return nil, err
+	}
+
+	version, err := parseVersion(raw["version"])
+	if err != nil {
+		return nil, err
+	}
+
+	if version < CurrentVersion {
+		if err := migrate(raw, version); err != nil {
+			return nil, err
+		}
+		if manifest, err = decodeRaw(raw); err != nil {
+			return nil, fmt.Errorf("failed to read migrated manifest: %w", err)
+		}
+	} else if _, err := toml.DecodeFile(path, &manifest); err != nil {
 		return nil, err
 	}
 
 	if manifest.Repos == nil {
 		manifest.Repos = make(map[string]RepoConfig)
 	}
+	for name, repo := range manifest.Repos {
+		manifest.Repos[name] = repo.mapPaths(m.cfg.Reroot)
+	}
 
 	return manifest.Repos, nil
 }
 
+// Save replaces the manifest atomically, so a crash or full disk leaves
+// either the old or the new one. The old one is kept as a backup.
 func (m *Manager) Save(repos map[string]RepoConfig) error {
+	// Fields a newer godotctl added would be lost
+	version := m.diskVersion()
+	if version > CurrentVersion {
+		return &NewerError{Path: m.path, Version: version}
+	}
+
+	// Only an intact manifest replaces the backup. One of an older schema is
+	// also kept for good, as this save migrates it.
+	if version > 0 {
+		data, err := os.ReadFile(m.path)
+		if err != nil {
+			return err
+		}
+
+		backups := []string{m.path + BackupSuffix}
+		if version < CurrentVersion {
+			backups = append(backups, fmt.Sprintf("%s.v%d.bak", m.path, version))
+		}
+		for _, backup := range backups {
+			err = writeFile(backup, func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to back up manifest: %w", err)
+			}
+		}
+	}
+
 	manifest := Manifest{
-		Version: "1.0",
-		Repos:   repos,
+		Version: formatVersion(CurrentVersion),
+		Repos:   make(map[string]RepoConfig, len(repos)),
+	}
+	for name, repo := range repos {
+		manifest.Repos[name] = repo.mapPaths(m.cfg.Unroot)
 	}
 
-	f, err := os.Create(m.path)
+	return writeFile(m.path, func(w io.Writer) error {
+		return toml.NewEncoder(w).Encode(manifest)
+	})
+}
+
+// writeFile replaces path with what write produces. The data goes to a
+// temporary file in the same directory, is flushed to disk and renamed over
+// path, so path is never left partially written.
+func writeFile(path string, write func(io.Writer) error) error {
+	dir := filepath.Dir(path)
+
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	err = write(f)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
 	if err != nil {
+		os.Remove(tmp)
 		return err
 	}
-	defer f.Close()
 
-	encoder := toml.NewEncoder(f)
-	return encoder.Encode(manifest)
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// Install describes one run of the install command
+type Install struct {
+	Name        string
+	URL         string
+	CachedAt    string
+	SourceType  installer.SourceType
+	Revision    *installer.Revision
+	Groups      []installer.DotfileGroup
+	Skipped     []string
+	Available   []string // Every group the repository has
+	Links       installer.LinkSet
+	Facts       installer.Facts
+	Resolutions map[string]installer.Resolution
 }
 
-func (m *Manager) AddRepo(name, url, cachedAt string, sourceType installer.SourceType, groups []installer.DotfileGroup, symlinks map[string]string) error {
+func (m *Manager) AddRepo(install Install) error {
 	repos, err := m.Load()
 	if err != nil {
 		return err
 	}
 
-	groupNames := make([]string, len(groups))
-	for i, g := range groups {
-		groupNames[i] = g.Name
+	// Reinstalling keeps what earlier installs of the same repo recorded
+	repo, exists := repos[install.Name]
+	if !exists {
+		repo.InstalledAt = time.Now()
+	}
+	if repo.Variants == nil {
+		repo.Variants = make(map[string]string)
+	}
+	if repo.Resolutions == nil {
+		repo.Resolutions = make(map[string]installer.Resolution)
+	}
+
+	for _, g := range install.Groups {
+		if !slices.Contains(repo.InstalledGroups, g.Name) {
+			repo.InstalledGroups = append(repo.InstalledGroups, g.Name)
+		}
+		repo.SkippedGroups = slices.DeleteFunc(repo.SkippedGroups, func(name string) bool {
+			return name == g.Name
+		})
+
+		if g.Variant != "" {
+			repo.Variants[g.Name] = g.Variant
+		} else {
+			delete(repo.Variants, g.Name)
+		}
+	}
+
+	for _, name := range install.Skipped {
+		if !slices.Contains(repo.SkippedGroups, name) && !slices.Contains(repo.InstalledGroups, name) {
+			repo.SkippedGroups = append(repo.SkippedGroups, name)
+		}
 	}
 
-	repos[name] = RepoConfig{
-		URL:             url,
-		SourceType:      sourceType,
-		CachedAt:        cachedAt,
-		InstalledAt:     time.Now(),
-		LastUpdated:     time.Now(),
-		InstalledGroups: groupNames,
-		Symlinks:        symlinks,
+	for path, resolution := range install.Resolutions {
+		repo.Resolutions[path] = resolution
 	}
 
+	merged := repo.Links()
+	merged.Merge(install.Links)
+	repo.SetLinks(merged)
+
+	repo.URL = install.URL
+	repo.SourceType = install.SourceType
+	repo.CachedAt = install.CachedAt
+	repo.Revision = install.Revision
+	repo.AvailableGroups = install.Available
+	repo.LastUpdated = time.Now()
+	repo.Facts = install.Facts
+	repos[install.Name] = repo
+
 	return m.Save(repos)
 }
 
-func (m *Manager) RemoveRepo(name string) error {
+// SetRepo replaces the stored configuration of a repository
+func (m *Manager) SetRepo(name string, repo RepoConfig) error {
 	repos, err := m.Load()
 	if err != nil {
 		return err
 	}
 
-	delete(repos, name)
+	repos[name] = repo
 	return m.Save(repos)
 }
 
-func (m *Manager) UpdateRepo(name string) error {
+func (m *Manager) RemoveRepo(name string) error {
 	repos, err := m.Load()
 	if err != nil {
 		return err
 	}
 
-	if repo, exists := repos[name]; exists {
-		repo.LastUpdated = time.Now()
-		repos[name] = repo
-		return m.Save(repos)
-	}
+	delete(repos, name)
+	return m.Save(repos)
+}
 
-	return nil
+// UpdateRepo stores the configuration of a repository after an update
+func (m *Manager) UpdateRepo(name string, repo RepoConfig) error {
+	repo.LastUpdated = time.Now()
+	return m.SetRepo(name, repo)
 }
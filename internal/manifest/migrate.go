@@ -0,0 +1,203 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/grainedlotus515/godotctl/internal/installer"
+)
+
+// CurrentVersion is the schema version this godotctl reads and writes
+const CurrentVersion = 3
+
+// migration upgrades a decoded manifest by one schema version. It works on
+// the raw TOML tables so fields that no longer exist are still reachable.
+type migration func(raw map[string]any) error
+
+// migrations are keyed by the version they upgrade from
+var migrations = map[int]migration{
+	1: describeLinks,
+	2: recordRevisions,
+}
+
+// NewerError reports a manifest written by a newer godotctl
+type NewerError struct {
+	Path    string
+	Version int
+}
+
+func (e *NewerError) Error() string {
+	return fmt.Sprintf("%s was written by a newer godotctl (schema %d, this one knows %d), refusing to overwrite it", e.Path, e.Version, CurrentVersion)
+}
+
+// formatVersion renders a schema version the way the manifest stores it
+func formatVersion(version int) string {
+	return strconv.Itoa(version) + ".0"
+}
+
+// parseVersion reads the schema version of a manifest. Only the major part
+// counts; manifests without one predate versioning.
+func parseVersion(value any) (int, error) {
+	if value == nil {
+		return 1, nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid manifest version %v", value)
+	}
+
+	major, _, _ := strings.Cut(s, ".")
+	version, err := strconv.Atoi(major)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid manifest version %q", s)
+	}
+
+	return version, nil
+}
+
+// migrate upgrades raw from version to CurrentVersion
+func migrate(raw map[string]any, version int) error {
+	for v := version; v < CurrentVersion; v++ {
+		migrate, ok := migrations[v]
+		if !ok {
+			return fmt.Errorf("no migration from manifest schema %d", v)
+		}
+		if err := migrate(raw); err != nil {
+			return fmt.Errorf("failed to migrate manifest from schema %d: %w", v, err)
+		}
+	}
+
+	raw["version"] = formatVersion(CurrentVersion)
+	return nil
+}
+
+// describeLinks adds an entry for every link and copy recorded by schema 1.
+// Each is assigned to the closest enclosing path named after an installed
+// group and dated to when the repository was installed.
+func describeLinks(raw map[string]any) error {
+	repos, _ := raw["repos"].(map[string]any)
+
+	for _, value := range repos {
+		repo, ok := value.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		var groups []string
+		if installed, ok := repo["installed_groups"].([]any); ok {
+			for _, group := range installed {
+				if name, ok := group.(string); ok {
+					groups = append(groups, name)
+				}
+			}
+		}
+		strategies, _ := repo["strategies"].(map[string]any)
+		installedAt, _ := repo["installed_at"].(time.Time)
+		cachedAt, _ := repo["cached_at"].(string)
+		commit := installer.HeadCommit(cachedAt)
+
+		entries := make(map[string]installer.LinkEntry)
+		describe := func(target, source string, strategy installer.Strategy) installer.LinkEntry {
+			group := groupOf(target, groups)
+			if strategy == "" {
+				name, _ := strategies[group].(string)
+				strategy, _ = installer.ParseStrategy(name)
+			}
+
+			entry := installer.DescribeLink(group, strategy, source, target, commit)
+			if !installedAt.IsZero() {
+				entry.CreatedAt = installedAt
+			}
+			return entry
+		}
+
+		symlinks, _ := repo["symlinks"].(map[string]any)
+		for target, value := range symlinks {
+			source, _ := value.(string)
+			entries[target] = describe(target, source, "")
+		}
+
+		// Only the hash of what godots wrote is known for copies
+		copies, _ := repo["copies"].(map[string]any)
+		for target, value := range copies {
+			entry := describe(target, "", installer.StrategyCopy)
+			entry.Hash, _ = value.(string)
+			entries[target] = entry
+		}
+
+		repo["entries"] = entries
+	}
+
+	return nil
+}
+
+// recordRevisions records the commit each cached repository has checked out,
+// which is the one its links point into
+func recordRevisions(raw map[string]any) error {
+	repos, _ := raw["repos"].(map[string]any)
+
+	for _, value := range repos {
+		repo, ok := value.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		cachedAt, _ := repo["cached_at"].(string)
+		if revision := installer.ReadRevision(cachedAt); revision != nil {
+			repo["revision"] = revision
+		}
+	}
+
+	return nil
+}
+
+// groupOf returns the installed group named by the closest of path and its parents
+func groupOf(path string, groups []string) string {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if slices.Contains(groups, filepath.Base(dir)) {
+			return filepath.Base(dir)
+		}
+		if dir == filepath.Dir(dir) {
+			return ""
+		}
+	}
+}
+
+// decodeRaw converts migrated tables into a Manifest
+func decodeRaw(raw map[string]any) (Manifest, error) {
+	var manifest Manifest
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+		return manifest, err
+	}
+
+	_, err := toml.Decode(buf.String(), &manifest)
+	return manifest, err
+}
+
+// diskVersion is the schema version of the manifest on disk, zero if there
+// is none that can be read. A missing or damaged manifest has nothing worth
+// keeping.
+func (m *Manager) diskVersion() int {
+	var head struct {
+		Version any `toml:"version"`
+	}
+
+	if _, err := toml.DecodeFile(m.path, &head); err != nil {
+		return 0
+	}
+
+	version, err := parseVersion(head.Version)
+	if err != nil {
+		return 0
+	}
+	return version
+}
@@ -0,0 +1,187 @@
+package manifest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/grainedlotus515/godotctl/internal/config"
+	"github.com/grainedlotus515/godotctl/internal/installer"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		value   any
+		want    int
+		wantErr bool
+	}{
+		{nil, 1, false},
+		{"1.0", 1, false},
+		{"3.0", 3, false},
+		{"3", 3, false},
+		{"12.4", 12, false},
+		{"0.9", 0, true},
+		{"-1.0", 0, true},
+		{"v2", 0, true},
+		{"", 0, true},
+		{int64(2), 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseVersion(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseVersion(%#v) = %d, %v, want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	installedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		manifest string
+		version  int
+		want     map[string]installer.LinkEntry
+	}{
+		{
+			name: "schema 1 links and copies get entries",
+			manifest: `
+[repos.dots]
+url = "https://example.com/dots"
+cached_at = ""
+installed_at = 2024-05-01T12:00:00Z
+installed_groups = ["nvim", "git"]
+[repos.dots.strategies]
+nvim = "tree"
+[repos.dots.symlinks]
+"/home/u/.config/nvim/init.lua" = "/cache/dots/config/nvim/init.lua"
+"/home/u/.config/other" = "/cache/dots/config/other"
+[repos.dots.copies]
+"/home/u/.config/git/config" = "abc123"
+`,
+			version: 1,
+			want: map[string]installer.LinkEntry{
+				"/home/u/.config/nvim/init.lua": {Group: "nvim", Strategy: installer.StrategyTree, Source: "/cache/dots/config/nvim/init.lua", CreatedAt: installedAt},
+				"/home/u/.config/other":         {Strategy: installer.StrategySymlink, Source: "/cache/dots/config/other", CreatedAt: installedAt},
+				"/home/u/.config/git/config":    {Group: "git", Strategy: installer.StrategyCopy, Hash: "abc123", CreatedAt: installedAt},
+			},
+		},
+		{
+			name: "schema 2 keeps its entries",
+			manifest: `
+version = "2.0"
+[repos.dots]
+url = "https://example.com/dots"
+cached_at = ""
+[repos.dots.entries."/home/u/.zshrc"]
+group = "zsh"
+strategy = "symlink"
+source = "/cache/dots/config/zsh/.zshrc"
+mode = 0
+created_at = 2024-05-01T12:00:00Z
+`,
+			version: 2,
+			want: map[string]installer.LinkEntry{
+				"/home/u/.zshrc": {Group: "zsh", Strategy: installer.StrategySymlink, Source: "/cache/dots/config/zsh/.zshrc", CreatedAt: installedAt},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw map[string]any
+			if _, err := toml.Decode(tt.manifest, &raw); err != nil {
+				t.Fatal(err)
+			}
+
+			version, err := parseVersion(raw["version"])
+			if err != nil || version != tt.version {
+				t.Fatalf("parseVersion = %d, %v, want %d", version, err, tt.version)
+			}
+			if err := migrate(raw, version); err != nil {
+				t.Fatal(err)
+			}
+
+			manifest, err := decodeRaw(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Version != formatVersion(CurrentVersion) {
+				t.Errorf("version = %q, want %q", manifest.Version, formatVersion(CurrentVersion))
+			}
+
+			repo := manifest.Repos["dots"]
+			if repo.Revision != nil {
+				t.Errorf("revision = %+v, want none for a cache that is not a git repository", repo.Revision)
+			}
+			if len(repo.Entries) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d: %+v", len(repo.Entries), len(tt.want), repo.Entries)
+			}
+			for target, want := range tt.want {
+				if got := repo.Entries[target]; got != want {
+					t.Errorf("entry %s = %+v, want %+v", target, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadVersions(t *testing.T) {
+	tests := []struct {
+		name      string
+		manifest  string
+		wantErr   bool
+		wantNewer bool
+	}{
+		{"unversioned", "[repos.dots]\nurl = \"u\"\n", false, false},
+		{"current", "version = \"3.0\"\n[repos.dots]\nurl = \"u\"\n", false, false},
+		{"newer", "version = \"4.0\"\n[repos.dots]\nurl = \"u\"\n", false, true},
+		{"invalid", "version = \"next\"\n", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.New(config.Options{Home: t.TempDir()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Dir(cfg.ManifestPath), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(cfg.ManifestPath, []byte(tt.manifest), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			man := New(cfg)
+			repos, err := man.Load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if repos["dots"].URL != "u" {
+				t.Errorf("Load() = %+v, want repository dots", repos)
+			}
+
+			var newer *NewerError
+			err = man.Save(repos)
+			if errors.As(err, &newer) != tt.wantNewer {
+				t.Fatalf("Save() error = %v, want newer error %v", err, tt.wantNewer)
+			}
+			if tt.wantNewer {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version := man.diskVersion(); version != CurrentVersion {
+				t.Errorf("saved schema %d, want %d", version, CurrentVersion)
+			}
+		})
+	}
+}
@@ -12,11 +12,105 @@ type Manifest struct {
 }
 
 type RepoConfig struct {
-	URL             string                  `toml:"url"`
-	SourceType      installer.SourceType    `toml:"source_type"`
-	CachedAt        string                  `toml:"cached_at"`
-	InstalledAt     time.Time               `toml:"installed_at"`
-	LastUpdated     time.Time               `toml:"last_updated"`
-	InstalledGroups []string                `toml:"installed_groups"`
-	Symlinks        map[string]string       `toml:"symlinks"`
+	URL             string                          `toml:"url"`
+	SourceType      installer.SourceType            `toml:"source_type"`
+	CachedAt        string                          `toml:"cached_at"`
+	InstalledAt     time.Time                       `toml:"installed_at"`
+	LastUpdated     time.Time                       `toml:"last_updated"`
+	Revision        *installer.Revision             `toml:"revision,omitempty"` // Deployed commit, nil for plain directories
+	InstalledGroups []string                        `toml:"installed_groups"`
+	SkippedGroups   []string                        `toml:"skipped_groups,omitempty"`
+	AvailableGroups []string                        `toml:"available_groups,omitempty"` // Groups the repository had at the last install or update
+	Symlinks        map[string]string               `toml:"symlinks"`
+	Dirs            []string                        `toml:"dirs,omitempty"`
+	Unfolded        map[string]string               `toml:"unfolded,omitempty"`
+	Strategies      map[string]installer.Strategy   `toml:"strategies,omitempty"`
+	Variants        map[string]string               `toml:"variants,omitempty"`
+	Rendered        []string                        `toml:"rendered,omitempty"`
+	Copies          map[string]string               `toml:"copies,omitempty"`
+	Entries         map[string]installer.LinkEntry  `toml:"entries,omitempty"`
+	Facts           installer.Facts                 `toml:"facts"`
+	Resolutions     map[string]installer.Resolution `toml:"resolutions,omitempty"`
+}
+
+// Links returns the recorded filesystem changes in the form the installer uses to undo them
+func (r RepoConfig) Links() installer.LinkSet {
+	return installer.LinkSet{
+		Symlinks:   r.Symlinks,
+		Dirs:       r.Dirs,
+		Unfolded:   r.Unfolded,
+		Strategies: r.Strategies,
+		Rendered:   r.Rendered,
+		Copies:     r.Copies,
+		Entries:    r.Entries,
+	}
+}
+
+// SetLinks stores the filesystem changes recorded in links
+func (r *RepoConfig) SetLinks(links installer.LinkSet) {
+	r.Symlinks = links.Symlinks
+	r.Dirs = links.Dirs
+	r.Unfolded = links.Unfolded
+	r.Strategies = links.Strategies
+	r.Rendered = links.Rendered
+	r.Copies = links.Copies
+	r.Entries = links.Entries
+}
+
+// mapPaths returns a copy of the repository with every recorded path passed
+// through fn, which converts between paths on this system and inside the root
+func (r RepoConfig) mapPaths(fn func(string) string) RepoConfig {
+	r.CachedAt = fn(r.CachedAt)
+	r.Symlinks = mapPathMap(r.Symlinks, fn, true)
+	r.Unfolded = mapPathMap(r.Unfolded, fn, true)
+	r.Copies = mapPathMap(r.Copies, fn, false)
+	r.Dirs = mapPathList(r.Dirs, fn)
+	r.Rendered = mapPathList(r.Rendered, fn)
+
+	if r.Entries != nil {
+		entries := make(map[string]installer.LinkEntry, len(r.Entries))
+		for path, entry := range r.Entries {
+			entry.Source = fn(entry.Source)
+			entries[fn(path)] = entry
+		}
+		r.Entries = entries
+	}
+
+	if r.Resolutions != nil {
+		resolutions := make(map[string]installer.Resolution, len(r.Resolutions))
+		for path, resolution := range r.Resolutions {
+			resolutions[fn(path)] = resolution
+		}
+		r.Resolutions = resolutions
+	}
+
+	return r
+}
+
+// mapPathMap maps the keys of paths, and the values too when they are paths
+func mapPathMap(paths map[string]string, fn func(string) string, values bool) map[string]string {
+	if paths == nil {
+		return nil
+	}
+
+	mapped := make(map[string]string, len(paths))
+	for key, value := range paths {
+		if values {
+			value = fn(value)
+		}
+		mapped[fn(key)] = value
+	}
+	return mapped
+}
+
+func mapPathList(paths []string, fn func(string) string) []string {
+	if paths == nil {
+		return nil
+	}
+
+	mapped := make([]string, len(paths))
+	for n, path := range paths {
+		mapped[n] = fn(path)
+	}
+	return mapped
 }
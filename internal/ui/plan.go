@@ -0,0 +1,72 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/grainedlotus515/godotctl/internal/installer"
+)
+
+var actionSymbols = map[installer.ActionKind]string{
+	installer.ActionLink:   "+ link  ",
+	installer.ActionMkdir:  "+ mkdir ",
+	installer.ActionWrite:  "+ write ",
+	installer.ActionRender: "~ render",
+	installer.ActionRemove: "- remove",
+	installer.ActionBackup: "> backup",
+	installer.ActionAdopt:  "> adopt ",
+}
+
+// PrintPlan shows what an operation would do, as a list or as JSON on stdout
+func PrintPlan(plan *installer.Plan, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+
+	PrintHeader(fmt.Sprintf("Plan: %s %s", plan.Operation, plan.Repo))
+
+	if plan.Fetch != "" {
+		fmt.Fprintf(output, "Repository: %s\n", plan.Fetch)
+	}
+	for _, commit := range plan.Commits {
+		fmt.Fprintf(output, "  %s\n", commit)
+	}
+	if len(plan.Groups) > 0 {
+		fmt.Fprintf(output, "Groups: %v\n", plan.Groups)
+	}
+	if len(plan.Skipped) > 0 {
+		fmt.Fprintf(output, "Skipped: %v\n", plan.Skipped)
+	}
+
+	if len(plan.Actions) == 0 {
+		fmt.Fprintln(output, "\nNo changes to the filesystem")
+	} else {
+		fmt.Fprintf(output, "\nChanges (%d):\n", len(plan.Actions))
+		for _, action := range plan.Actions {
+			line := fmt.Sprintf("  %s %s", actionSymbols[action.Kind], action.Path)
+			if action.Source != "" {
+				line += " -> " + action.Source
+			}
+			fmt.Fprintln(output, line)
+		}
+	}
+
+	if len(plan.Hooks) > 0 {
+		fmt.Fprintln(output, "\nHooks:")
+		for _, hook := range plan.Hooks {
+			fmt.Fprintf(output, "  %s\n", hook)
+		}
+	}
+
+	if len(plan.Manifest) > 0 {
+		fmt.Fprintln(output, "\nManifest:")
+		for _, change := range plan.Manifest {
+			fmt.Fprintf(output, "  %s\n", change)
+		}
+	}
+
+	return nil
+}
@@ -2,6 +2,9 @@ package ui
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/lipgloss"
@@ -16,24 +19,32 @@ var (
 	warnStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
 )
 
+// output receives all messages, so machine-readable output can own stdout
+var output io.Writer = os.Stdout
+
+// SetOutput redirects messages, e.g. to stderr
+func SetOutput(w io.Writer) {
+	output = w
+}
+
 func PrintHeader(msg string) {
-	fmt.Println(headerStyle.Render(fmt.Sprintf("\n━━━ %s ━━━", msg)))
+	fmt.Fprintln(output, headerStyle.Render(fmt.Sprintf("\n━━━ %s ━━━", msg)))
 }
 
 func PrintSuccess(msg string) {
-	fmt.Println(successStyle.Render("✓ " + msg))
+	fmt.Fprintln(output, successStyle.Render("✓ "+msg))
 }
 
 func PrintError(msg string) {
-	fmt.Println(errorStyle.Render("✗ " + msg))
+	fmt.Fprintln(output, errorStyle.Render("✗ "+msg))
 }
 
 func PrintInfo(msg string) {
-	fmt.Println(infoStyle.Render("➜ " + msg))
+	fmt.Fprintln(output, infoStyle.Render("➜ "+msg))
 }
 
 func PrintWarning(msg string) {
-	fmt.Println(warnStyle.Render("⚠ " + msg))
+	fmt.Fprintln(output, warnStyle.Render("⚠ "+msg))
 }
 
 func PromptSelectGroups(groups []installer.DotfileGroup) ([]installer.DotfileGroup, error) {
@@ -95,3 +106,86 @@ func PromptConfirm(message string) (bool, error) {
 
 	return confirm, nil
 }
+
+// PromptConflict asks how to handle one conflicting path. Choosing to show
+// the diff calls showDiff and asks again. When other conflicts remain, the
+// user can apply the choice to all of them.
+func PromptConflict(conflict installer.Conflict, remaining int, showDiff func() error) (installer.Resolution, bool, error) {
+	const diff = "diff"
+
+	for {
+		var choice string
+
+		form := huh.NewForm(
+			huh.NewGroup(
+				huh.NewSelect[string]().
+					Title(fmt.Sprintf("%s already exists (group %s)", conflict.Path, conflict.Group)).
+					Options(
+						huh.NewOption("Backup and replace", string(installer.ResolveBackup)),
+						huh.NewOption("Skip this group", string(installer.ResolveSkip)),
+						huh.NewOption("Overwrite (no backup)", string(installer.ResolveOverwrite)),
+						huh.NewOption("Adopt into repository", string(installer.ResolveAdopt)),
+						huh.NewOption("Show diff", diff),
+					).
+					Value(&choice),
+			),
+		)
+
+		if err := form.Run(); err != nil {
+			return "", false, err
+		}
+
+		if choice == diff {
+			if err := showDiff(); err != nil {
+				PrintWarning(err.Error())
+			}
+			continue
+		}
+
+		applyAll := false
+		if remaining > 0 {
+			var err error
+			applyAll, err = PromptConfirm(fmt.Sprintf("Apply to all %d remaining conflicts?", remaining))
+			if err != nil {
+				return "", false, err
+			}
+		}
+
+		return installer.Resolution(choice), applyAll, nil
+	}
+}
+
+// Ways to deal with an interrupted operation
+const (
+	RecoverResume   = "resume"
+	RecoverRollback = "rollback"
+	RecoverLeave    = "leave"
+)
+
+// PromptRecovery asks whether to resume or roll back an interrupted operation
+func PromptRecovery(journal *installer.Journal) (string, error) {
+	var choice string
+
+	options := []huh.Option[string]{
+		huh.NewOption(fmt.Sprintf("Roll back %d changes", len(journal.Steps)), RecoverRollback),
+	}
+	if len(journal.Command) > 0 {
+		options = append(options, huh.NewOption("Resume: godotctl "+strings.Join(journal.Command, " "), RecoverResume))
+	}
+	options = append(options, huh.NewOption("Leave it for now", RecoverLeave))
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title(fmt.Sprintf("The %s of %s did not finish", journal.Operation, journal.Repo)).
+				Options(options...).
+				Value(&choice),
+		),
+	)
+
+	if err := form.Run(); err != nil {
+		return "", err
+	}
+
+	return choice, nil
+}